@@ -0,0 +1,127 @@
+// Copyright 2017 Sergio Correia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+type addressConfig struct {
+	// IPv6 also lists the global IPv6 addresses.
+	IPv6 bool
+	// Tunnel is the VPN interface expected to be up, such as "wg0".
+	// If set, the module becomes urgent whenever it is down.
+	Tunnel string
+}
+
+var (
+	// Prefixes of the interfaces created by WireGuard and by
+	// tun/tap based VPNs, such as OpenVPN.
+	tunnelPrefixes = []string{"wg", "tun", "tap"}
+)
+
+// interfaceAddresses returns the IPv4 and, if enabled, global IPv6
+// addresses of a network interface.
+func interfaceAddresses(name string) []string {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil
+	}
+
+	var addresses []string
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok || ipnet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+
+		if ipnet.IP.To4() == nil && !config.Address.IPv6 {
+			continue
+		}
+		addresses = append(addresses, ipnet.IP.String())
+	}
+	return addresses
+}
+
+// activeTunnels returns the VPN interfaces that are up.
+func activeTunnels() []string {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+
+	var tunnels []string
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 {
+			continue
+		}
+
+		for _, prefix := range tunnelPrefixes {
+			if strings.HasPrefix(iface.Name, prefix) {
+				tunnels = append(tunnels, iface.Name)
+				break
+			}
+		}
+	}
+	return tunnels
+}
+
+func collectAddress(key string) {
+	var values []string
+	for _, iface := range activeInterfaces() {
+		values = append(values, interfaceAddresses(iface)...)
+	}
+
+	tunnels := activeTunnels()
+	values = append(values, tunnels...)
+
+	expected := config.Address.Tunnel
+	tunnelUp := len(expected) == 0
+	for _, tunnel := range tunnels {
+		if tunnel == expected {
+			tunnelUp = true
+		}
+	}
+
+	if !tunnelUp {
+		values = append(values, fmt.Sprintf("%s down", expected))
+		formatData(key, strings.Join(values, " "), icons["vpn_off"], formatUrgent)
+		return
+	}
+
+	if len(values) == 0 {
+		removeKey(key)
+		return
+	}
+
+	icon := icons[key]
+	if len(tunnels) > 0 {
+		icon = icons["vpn"]
+	}
+
+	formatData(key, strings.Join(values, " "), icon, formatDefault)
+}
+
+func init() {
+	registerFunc("ip", 10*time.Second, collectAddress)
+}
@@ -0,0 +1,172 @@
+// Copyright 2017 Sergio Correia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+)
+
+const (
+	backlightPath = "/sys/class/backlight"
+)
+
+type backlightConfig struct {
+	// Devices lists the backlight devices to display, such as
+	// "intel_backlight" or "amdgpu_bl0". If empty, the most suitable
+	// device is picked automatically.
+	Devices []string
+}
+
+// backlightCollector reports the brightness of one or more panels.
+// It is refreshed whenever their brightness changes.
+type backlightCollector struct {
+	// warned is set once watching the devices failed, so that the
+	// retries do not flood the log.
+	warned bool
+}
+
+// backlightPriority ranks backlight types as the kernel documentation
+// suggests: firmware interfaces are preferred over platform specific
+// ones, which in turn are preferred over raw access to the hardware.
+var backlightPriority = map[string]int{
+	"firmware": 0,
+	"platform": 1,
+	"raw":      2,
+}
+
+// discoverBacklights returns every device in /sys/class/backlight.
+func discoverBacklights() []string {
+	entries, err := ioutil.ReadDir(backlightPath)
+	if err != nil {
+		return nil
+	}
+
+	var devices []string
+	for _, entry := range entries {
+		devices = append(devices, entry.Name())
+	}
+	return devices
+}
+
+// backlightDevices returns the devices to display: either the ones in
+// the config file, or the single best discovered device. Multiple
+// devices usually drive the same panel, so only one is picked.
+func backlightDevices() []string {
+	if len(config.Backlight.Devices) > 0 {
+		return config.Backlight.Devices
+	}
+
+	best, bestPriority := "", len(backlightPriority)
+	for _, device := range discoverBacklights() {
+		kind, _ := readSysfsString(filepath.Join(backlightPath, device, "type"))
+		priority, ok := backlightPriority[kind]
+		if !ok {
+			priority = len(backlightPriority)
+		}
+
+		if best == "" || priority < bestPriority {
+			best, bestPriority = device, priority
+		}
+	}
+
+	if best == "" {
+		return nil
+	}
+	return []string{best}
+}
+
+// readBrightness returns the brightness of a device, in percentage.
+func readBrightness(device string) (int, error) {
+	actual, err := readSysfsInt(filepath.Join(backlightPath, device, "actual_brightness"))
+	if err != nil {
+		return 0, err
+	}
+
+	max, err := readSysfsInt(filepath.Join(backlightPath, device, "max_brightness"))
+	if err != nil {
+		return 0, err
+	}
+	if max <= 0 {
+		return 0, fmt.Errorf("backlight %s has invalid max_brightness %d", device, max)
+	}
+
+	return 100 * actual / max, nil
+}
+
+func (b *backlightCollector) Name() string {
+	return "brightness"
+}
+
+// Interval is long, as changes are notified via inotify.
+func (b *backlightCollector) Interval() time.Duration {
+	return 30 * time.Second
+}
+
+func (b *backlightCollector) Collect() {
+	key := b.Name()
+
+	value := ""
+	for _, device := range backlightDevices() {
+		cur, err := readBrightness(device)
+		if err != nil {
+			continue
+		}
+
+		if len(value) > 0 {
+			value += " "
+		}
+		value += progressBar(cur)
+	}
+
+	if len(value) == 0 {
+		removeKey(key)
+		return
+	}
+
+	formatData(key, value, icons[key], formatDefault)
+}
+
+func (b *backlightCollector) watch() {
+	for {
+		var paths []string
+		for _, device := range discoverBacklights() {
+			paths = append(paths, filepath.Join(backlightPath, device, "brightness"), filepath.Join(backlightPath, device, "actual_brightness"))
+		}
+
+		// Devices are discovered again on every interval, so that
+		// panels connected later are also watched.
+		var err error
+		if len(paths) > 0 {
+			err = watchFiles(paths, b.Interval(), func() { requestRefresh(b.Name()) })
+		} else {
+			time.Sleep(b.Interval())
+		}
+
+		if err != nil {
+			if !b.warned {
+				fmt.Printf("Unable to watch backlight changes: %s\n", err)
+				b.warned = true
+			}
+			time.Sleep(b.Interval())
+		}
+	}
+}
+
+func init() {
+	registerCollector(&backlightCollector{})
+}
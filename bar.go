@@ -0,0 +1,81 @@
+// Copyright 2017 Sergio Correia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// backend displays the collected info with a status bar program.
+type backend interface {
+	// start prepares the backend, once, before the bar is first drawn.
+	start()
+	// draw creates the bars, or recreates them after the config is
+	// reloaded.
+	draw()
+	// update refreshes the bars with the current contents of data.
+	update()
+	// status returns the line update writes to the bar, on the first
+	// monitor if there are several.
+	status() string
+}
+
+var (
+	activeBackend backend
+
+	// stdout is the original standard output, which backends such as
+	// i3bar use to talk to the bar. os.Stdout is redirected to stderr
+	// on startup, so that diagnostics never get mixed with the status.
+	stdout = os.Stdout
+
+	// onceMode is set when the status is printed a single time, with
+	// --once or print. The process exits right after, so modules must
+	// not leave work to be finished in the background.
+	onceMode bool
+)
+
+// selectBackend returns the backend configured by the output option:
+// "dzen", the default, "lemonbar", "i3bar", for i3bar and swaybar, or
+// one of the terminal ones, "plain", "ansi", "ansi256" and "tmux".
+func selectBackend() backend {
+	switch config.Output {
+	case "", "dzen":
+		return &dzenBackend{}
+	case "lemonbar":
+		return &lemonbarBackend{}
+	case "i3bar", "swaybar":
+		return &i3barBackend{}
+	case "plain", "ansi", "ansi256", "tmux":
+		return &terminalBackend{markup: config.Output}
+	}
+
+	fmt.Printf("Output '%s' does not exist; please recheck the config file. Using dzen\n", config.Output)
+	return &dzenBackend{}
+}
+
+func updateStatusBar() {
+	collectDueStats()
+
+	activeBackend.update()
+}
+
+// reloadStatusBar collects only the given modules and redraws the
+// bar, reusing the info of the other modules.
+func reloadStatusBar(names ...string) {
+	collectKeys(names...)
+
+	activeBackend.update()
+}
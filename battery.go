@@ -0,0 +1,253 @@
+// Copyright 2017 Sergio Correia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+)
+
+const (
+	powerSupplyPath = "/sys/class/power_supply"
+)
+
+type batteryConfig struct {
+	// Time shows the time to empty, when discharging, or the time to
+	// full, when charging.
+	Time bool
+	// Power shows the current power draw, in watts.
+	Power bool
+	// Health shows the full capacity relative to the design one.
+	Health bool
+}
+
+// batteryStatus aggregates the state of every battery in the system.
+// Energies are in µWh and power in µW, as exported by sysfs.
+type batteryStatus struct {
+	batteries    int
+	energyNow    int
+	energyFull   int
+	energyDesign int
+	// capacity sums the charge percentage of the batteries that do not
+	// report their energy, which are counted in capacityOnly.
+	capacity     int
+	capacityOnly int
+	power        int
+	charging     bool
+	discharging  bool
+	acOnline     bool
+}
+
+var (
+	// batteryWarned holds the batteries that could not be read, so
+	// that they are only reported once, until they can be read again.
+	batteryWarned = make(map[string]bool)
+)
+
+// readBatteryEnergy reads an energy_* attribute of a battery, falling
+// back to the equivalent charge_* one, in µAh, converted to µWh.
+func readBatteryEnergy(dir, attr string, voltage int) (int, error) {
+	if energy, err := readSysfsInt(filepath.Join(dir, "energy_"+attr)); err == nil {
+		return energy, nil
+	}
+
+	charge, err := readSysfsInt(filepath.Join(dir, "charge_"+attr))
+	if err != nil {
+		return 0, err
+	}
+	return int(int64(charge) * int64(voltage) / 1000000), nil
+}
+
+// readBattery adds a single battery to the aggregated status.
+func readBattery(dir string, status *batteryStatus) error {
+	// Peripherals, like mice and keyboards, also report batteries.
+	if scope, err := readSysfsString(filepath.Join(dir, "scope")); err == nil && scope == "Device" {
+		return nil
+	}
+
+	// Batteries in removable bays are still listed when removed.
+	if present, err := readSysfsInt(filepath.Join(dir, "present")); err == nil && present == 0 {
+		return nil
+	}
+
+	voltage, err := readSysfsInt(filepath.Join(dir, "voltage_now"))
+	if err != nil {
+		voltage, _ = readSysfsInt(filepath.Join(dir, "voltage_min_design"))
+	}
+
+	now, nowErr := readBatteryEnergy(dir, "now", voltage)
+	full, fullErr := readBatteryEnergy(dir, "full", voltage)
+	energy := nowErr == nil && fullErr == nil && full > 0
+
+	// Some batteries, e.g. on ARM boards, only report the percentage.
+	capacity, err := readSysfsInt(filepath.Join(dir, "capacity"))
+	if !energy && err != nil {
+		return fmt.Errorf("battery %s has neither a valid full capacity nor a capacity percentage", dir)
+	}
+
+	power, err := readSysfsInt(filepath.Join(dir, "power_now"))
+	if err != nil {
+		current, _ := readSysfsInt(filepath.Join(dir, "current_now"))
+		power = int(int64(current) * int64(voltage) / 1000000)
+	}
+	if power < 0 {
+		// Some drivers report a negative rate when discharging.
+		power = -power
+	}
+
+	state, _ := readSysfsString(filepath.Join(dir, "status"))
+	switch state {
+	case "Charging":
+		status.charging = true
+	case "Discharging":
+		status.discharging = true
+	}
+
+	status.batteries++
+	status.power += power
+	if !energy {
+		status.capacity += capacity
+		status.capacityOnly++
+		return nil
+	}
+
+	design, err := readBatteryEnergy(dir, "full_design", voltage)
+	if err != nil || design <= 0 {
+		design = full
+	}
+	status.energyNow += now
+	status.energyFull += full
+	status.energyDesign += design
+	return nil
+}
+
+// readPowerSupplies collects the state of the batteries and of the AC
+// adapters listed in /sys/class/power_supply.
+func readPowerSupplies() (batteryStatus, error) {
+	var status batteryStatus
+
+	supplies, err := ioutil.ReadDir(powerSupplyPath)
+	if err != nil {
+		return status, err
+	}
+
+	for _, supply := range supplies {
+		dir := filepath.Join(powerSupplyPath, supply.Name())
+		kind, err := readSysfsString(filepath.Join(dir, "type"))
+		if err != nil {
+			continue
+		}
+
+		switch kind {
+		case "Battery":
+			if err = readBattery(dir, &status); err != nil {
+				if !batteryWarned[supply.Name()] {
+					fmt.Printf("Unable to read battery '%s': %s\n", supply.Name(), err)
+					batteryWarned[supply.Name()] = true
+				}
+				continue
+			}
+			delete(batteryWarned, supply.Name())
+		case "Mains", "USB":
+			if online, err := readSysfsInt(filepath.Join(dir, "online")); err == nil && online == 1 {
+				status.acOnline = true
+			}
+		}
+	}
+	return status, nil
+}
+
+// formatDuration formats a duration as h:mm.
+func formatDuration(d time.Duration) string {
+	minutes := int(d.Minutes())
+	return fmt.Sprintf("%d:%02d", minutes/60, minutes%60)
+}
+
+func collectBattery(key string) {
+	status, err := readPowerSupplies()
+	if err != nil || status.batteries == 0 {
+		removeKey(key)
+		return
+	}
+
+	// Batteries that only report a percentage cannot be weighted by
+	// their energy, so their percentage is averaged with the charge of
+	// the others.
+	value := status.capacity
+	if status.energyFull > 0 {
+		value += status.energyNow * 100 / status.energyFull * (status.batteries - status.capacityOnly)
+	}
+	value /= status.batteries
+	if value > 100 {
+		value = 100
+	}
+
+	var iconName string
+	switch {
+	case value > 75:
+		iconName = "battery_full"
+	case value > 50:
+		iconName = "battery_three_quarters"
+	case value > 25:
+		iconName = "battery_half"
+	case value > 10:
+		iconName = "battery_quarter"
+	default:
+		iconName = "battery_empty"
+	}
+
+	// When plugged in, the battery will not discharge, even if it is
+	// not charging at the moment.
+	if status.charging || (status.acOnline && !status.discharging) {
+		iconName = fmt.Sprintf("%s_power", iconName)
+	}
+
+	format := formatDefault
+	if value <= 10 {
+		format = formatUrgent
+	}
+
+	text := progressBar(value)
+
+	// The time and the health need the energy of every battery.
+	if config.Battery.Time && status.power > 0 && status.capacityOnly == 0 {
+		var hours float64
+		switch {
+		case status.discharging:
+			hours = float64(status.energyNow) / float64(status.power)
+		case status.charging:
+			hours = float64(status.energyFull-status.energyNow) / float64(status.power)
+		}
+		if hours > 0 {
+			text = fmt.Sprintf("%s %s", text, formatDuration(time.Duration(hours*float64(time.Hour))))
+		}
+	}
+
+	if config.Battery.Power {
+		text = fmt.Sprintf("%s %.1fW", text, float64(status.power)/1000000.0)
+	}
+
+	if config.Battery.Health && status.capacityOnly == 0 {
+		text = fmt.Sprintf("%s %d%%", text, status.energyFull*100/status.energyDesign)
+	}
+
+	formatData(key, text, icons[iconName], format)
+}
+
+func init() {
+	registerFunc("battery", 30*time.Second, collectBattery)
+}
@@ -0,0 +1,249 @@
+// Copyright 2017 Sergio Correia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	defaultClockFormat = "15:04:05"
+	defaultDateFormat  = "Mon Jan 2"
+	defaultZoneFormat  = "15:04"
+)
+
+type zoneConfig struct {
+	// Name is the IANA time zone, such as "America/Sao_Paulo".
+	Name string
+	// Label is displayed before the time. If empty, the last part of
+	// the zone name is used, e.g. "Sao_Paulo".
+	Label string
+}
+
+type clockConfig struct {
+	// Format, DateFormat and ZoneFormat are the formats of the clock,
+	// date and worldclock modules. They are either Go layouts, such
+	// as "15:04:05", or strftime(3) ones, such as "%H:%M:%S".
+	Format     string
+	DateFormat string
+	ZoneFormat string
+	// Zones lists the time zones displayed by the worldclock module.
+	Zones []zoneConfig
+}
+
+// timeCollector displays the current time with a configurable format.
+type timeCollector struct {
+	name string
+	icon string
+	// format returns the configured format, or the default one.
+	format func() string
+}
+
+// worldClockCollector displays the time in each configured time zone.
+type worldClockCollector struct {
+	// warned holds the invalid zones already reported, so that they
+	// are only reported once.
+	warned map[string]bool
+}
+
+var (
+	// strftimeLayouts maps strftime(3) conversions to Go layouts.
+	strftimeLayouts = map[byte]string{
+		'a': "Mon",
+		'A': "Monday",
+		'b': "Jan",
+		'B': "January",
+		'd': "02",
+		'D': "01/02/06",
+		'e': "_2",
+		'F': "2006-01-02",
+		'h': "Jan",
+		'H': "15",
+		'I': "03",
+		'j': "002",
+		'm': "01",
+		'M': "04",
+		'p': "PM",
+		'R': "15:04",
+		'S': "05",
+		'T': "15:04:05",
+		'y': "06",
+		'Y': "2006",
+		'z': "-0700",
+		'Z': "MST",
+	}
+
+	locations = make(map[string]*time.Location)
+)
+
+// isStrftime reports whether a format uses strftime(3) conversions,
+// rather than being a Go layout.
+func isStrftime(format string) bool {
+	return strings.Contains(format, "%")
+}
+
+// strftime formats t according to a strftime(3) format. Conversions
+// that are not supported are kept verbatim.
+func strftime(t time.Time, format string) string {
+	var b strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i == len(format)-1 {
+			b.WriteByte(format[i])
+			continue
+		}
+
+		i++
+		switch c := format[i]; c {
+		case '%':
+			b.WriteByte('%')
+		case 'n':
+			b.WriteByte(' ')
+		default:
+			if layout, ok := strftimeLayouts[c]; ok {
+				b.WriteString(t.Format(layout))
+			} else {
+				b.WriteByte('%')
+				b.WriteByte(c)
+			}
+		}
+	}
+	return b.String()
+}
+
+// formatTime formats t with either a Go layout or a strftime(3)
+// format.
+func formatTime(t time.Time, format string) string {
+	if isStrftime(format) {
+		return strftime(t, format)
+	}
+	return t.Format(format)
+}
+
+// hasSeconds reports whether a format displays seconds, in which case
+// it needs to be refreshed every second, rather than every minute.
+func hasSeconds(format string) bool {
+	if isStrftime(format) {
+		return strings.Contains(format, "%S") || strings.Contains(format, "%T")
+	}
+	return strings.Contains(format, "05")
+}
+
+func clockFormat() string {
+	if len(config.Clock.Format) > 0 {
+		return config.Clock.Format
+	}
+	return defaultClockFormat
+}
+
+func dateFormat() string {
+	if len(config.Clock.DateFormat) > 0 {
+		return config.Clock.DateFormat
+	}
+	return defaultDateFormat
+}
+
+func zoneFormat() string {
+	if len(config.Clock.ZoneFormat) > 0 {
+		return config.Clock.ZoneFormat
+	}
+	return defaultZoneFormat
+}
+
+// loadLocation returns the named time zone, caching it as it requires
+// reading the zoneinfo database.
+func loadLocation(name string) (*time.Location, error) {
+	if location, ok := locations[name]; ok {
+		return location, nil
+	}
+
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	locations[name] = location
+	return location, nil
+}
+
+func (c *timeCollector) Name() string {
+	return c.name
+}
+
+// clockInterval returns how often a clock with the given format has to
+// be updated.
+func clockInterval(format string) time.Duration {
+	if hasSeconds(format) {
+		return time.Second
+	}
+	return time.Minute
+}
+
+func (c *timeCollector) Interval() time.Duration {
+	return clockInterval(c.format())
+}
+
+func (c *timeCollector) Collect() {
+	t := time.Now()
+
+	formatData(c.Name(), formatTime(t, c.format()), icons[c.icon], formatDefault)
+}
+
+func (w *worldClockCollector) Name() string {
+	return "worldclock"
+}
+
+func (w *worldClockCollector) Interval() time.Duration {
+	return clockInterval(zoneFormat())
+}
+
+func (w *worldClockCollector) Collect() {
+	key := w.Name()
+	t := time.Now()
+
+	var values []string
+	for _, zone := range config.Clock.Zones {
+		location, err := loadLocation(zone.Name)
+		if err != nil {
+			if w.warned == nil {
+				w.warned = make(map[string]bool)
+			}
+			if !w.warned[zone.Name] {
+				fmt.Printf("Time zone '%s' is not valid: %s; please recheck the config file\n", zone.Name, err)
+				w.warned[zone.Name] = true
+			}
+			continue
+		}
+
+		label := zone.Label
+		if len(label) == 0 {
+			label = zone.Name[strings.LastIndex(zone.Name, "/")+1:]
+		}
+		values = append(values, fmt.Sprintf("%s %s", label, formatTime(t.In(location), zoneFormat())))
+	}
+
+	if len(values) == 0 {
+		removeKey(key)
+		return
+	}
+
+	formatData(key, strings.Join(values, " "), icons[key], formatDefault)
+}
+
+func init() {
+	registerCollector(&timeCollector{name: "clock", icon: "clock", format: clockFormat})
+	registerCollector(&timeCollector{name: "date", icon: "calendar", format: dateFormat})
+	registerCollector(&worldClockCollector{})
+}
@@ -0,0 +1,264 @@
+// Copyright 2017 Sergio Correia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Collector is implemented by every module that contributes an entry
+// to the status bar.
+type Collector interface {
+	// Name returns the key under which the module stores its info in
+	// data, and that is used to look up its icon.
+	Name() string
+	// Interval returns how often the module should be collected.
+	Interval() time.Duration
+	// Collect gathers the module information and stores it in data,
+	// via formatData, or removes it with removeKey if unavailable.
+	Collect()
+}
+
+// watcher is implemented by modules that can be notified of changes,
+// rather than only polled on their interval. watch runs in its own
+// goroutine, started only once, and should call requestRefresh
+// whenever the module needs to be collected again. It should keep
+// watching for as long as the program runs.
+type watcher interface {
+	watch()
+}
+
+// moduleConfig selects a module to be displayed, in the config file.
+type moduleConfig struct {
+	Name string
+	// Side is either "left", to show the module in the left bar, or
+	// "right" (the default), for the main bar.
+	Side string
+	// Interval optionally overrides the module refresh interval, in
+	// seconds.
+	Interval int
+}
+
+// module is an enabled collector, as configured by the user.
+type module struct {
+	Collector
+	side     string
+	interval time.Duration
+}
+
+// funcCollector adapts a plain collectXxx(key) function to the
+// Collector interface.
+type funcCollector struct {
+	name     string
+	interval time.Duration
+	collect  func(key string)
+}
+
+var (
+	// registry holds every available module.
+	registry []Collector
+
+	// defaultModules are shown, in the main bar, when the config file
+	// does not list any module. Other modules have to be enabled
+	// explicitly.
+	defaultModules = []string{"clock", "rx", "tx", "volume", "battery", "brightness", "cpu", "ram"}
+
+	// collectors holds the enabled modules, in the order they are
+	// displayed in the status bar.
+	collectors []*module
+
+	// lastCollected keeps track of when each module last ran, so that
+	// the cached info in data is reused until its interval elapses.
+	lastCollected = make(map[string]time.Time)
+
+	// refreshChan receives the names of modules that need to be
+	// collected right away, outside of their interval.
+	refreshChan = make(chan string, 16)
+
+	// watching tracks the modules whose watchers were started.
+	watching = make(map[string]bool)
+)
+
+func (m *module) Interval() time.Duration {
+	if m.interval > 0 {
+		return m.interval
+	}
+	return m.Collector.Interval()
+}
+
+func (f *funcCollector) Name() string {
+	return f.name
+}
+
+func (f *funcCollector) Interval() time.Duration {
+	return f.interval
+}
+
+func (f *funcCollector) Collect() {
+	f.collect(f.name)
+}
+
+// registerCollector makes a module available to the status bar.
+func registerCollector(c Collector) {
+	registry = append(registry, c)
+}
+
+// registerFunc registers a collectXxx(key) function as a module.
+func registerFunc(name string, interval time.Duration, collect func(key string)) {
+	registerCollector(&funcCollector{name: name, interval: interval, collect: collect})
+}
+
+// findCollector returns the enabled module with the given name.
+func findCollector(name string) (*module, bool) {
+	for _, m := range collectors {
+		if m.Name() == name {
+			return m, true
+		}
+	}
+	return nil, false
+}
+
+// loadModules enables the modules listed in the config file, in the
+// given order. If none is listed, the default modules are shown in the
+// main bar.
+func loadModules() {
+	collectors = nil
+
+	modules := config.Modules
+	if len(modules) == 0 {
+		for _, name := range defaultModules {
+			modules = append(modules, moduleConfig{Name: name})
+		}
+	}
+
+	for _, mc := range modules {
+		var found Collector
+		for _, c := range registry {
+			if c.Name() == mc.Name {
+				found = c
+				break
+			}
+		}
+
+		if found == nil {
+			fmt.Printf("Module '%s' does not exist; please recheck the config file\n", mc.Name)
+			continue
+		}
+
+		if _, ok := findCollector(mc.Name); ok {
+			fmt.Printf("Module '%s' is listed more than once; ignoring duplicate\n", mc.Name)
+			continue
+		}
+
+		side := "right"
+		switch mc.Side {
+		case "", "right":
+		case "left":
+			side = "left"
+		default:
+			fmt.Printf("Side '%s' of module '%s' does not exist; please recheck the config file. Using right\n", mc.Side, mc.Name)
+		}
+		collectors = append(collectors, &module{Collector: found, side: side, interval: time.Duration(mc.Interval) * time.Second})
+	}
+}
+
+// requestRefresh asks the main loop to collect the given module and
+// redraw the bar. It never blocks: if there are already too many
+// pending requests, the bar will be redrawn soon anyway.
+func requestRefresh(name string) {
+	select {
+	case refreshChan <- name:
+	default:
+	}
+}
+
+// startWatchers starts the watchers of enabled modules that are not
+// running yet.
+func startWatchers() {
+	for _, m := range collectors {
+		w, ok := m.Collector.(watcher)
+		if !ok || watching[m.Name()] {
+			continue
+		}
+		watching[m.Name()] = true
+		go w.watch()
+	}
+}
+
+// collect runs a single collector and records when it happened.
+func collect(c *module, now time.Time) {
+	c.Collect()
+	lastCollected[c.Name()] = now
+}
+
+// nextDue returns when the given collector should run again. Due
+// times are aligned to multiples of the interval, so that e.g. the
+// clock ticks at the beginning of each second.
+func nextDue(c *module) time.Time {
+	last, ok := lastCollected[c.Name()]
+	if !ok {
+		return time.Time{}
+	}
+	interval := c.Interval()
+	if interval <= 0 {
+		interval = time.Second
+	}
+	return last.Truncate(interval).Add(interval)
+}
+
+// nextCollection returns the earliest time at which some module is
+// due, so that the main loop can sleep until then.
+func nextCollection() time.Time {
+	var next time.Time
+	for i, c := range collectors {
+		if due := nextDue(c); i == 0 || due.Before(next) {
+			next = due
+		}
+	}
+	if len(collectors) == 0 {
+		next = time.Now().Truncate(time.Second).Add(time.Second)
+	}
+	return next
+}
+
+// collectKeys runs the collectors of the given modules only.
+func collectKeys(names ...string) {
+	now := time.Now()
+	for _, name := range names {
+		if c, ok := findCollector(name); ok {
+			collect(c, now)
+		}
+	}
+}
+
+// collectDueStats runs only the collectors whose interval has
+// elapsed, keeping the previously collected info for the others.
+func collectDueStats() {
+	now := time.Now()
+	for _, c := range collectors {
+		if !nextDue(c).After(now) {
+			collect(c, now)
+		}
+	}
+}
+
+// collectStats runs every collector, regardless of their intervals.
+func collectStats() {
+	now := time.Now()
+	for _, c := range collectors {
+		collect(c, now)
+	}
+}
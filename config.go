@@ -28,13 +28,29 @@ type wmIcon struct {
 
 type wmConfig struct {
 	SoundDevice      string
+	Microphone       string
 	NetworkInterface string
 	Font             string
 	WmSocket         string
 	Icons            []wmIcon
 	Colors           colorInfo
 	Bar              barConfig
+	Output           string
 	Popups           popupConfig
+	Modules          []moduleConfig
+	CPU              cpuConfig
+	RAM              ramConfig
+	Battery          batteryConfig
+	Backlight        backlightConfig
+	Network          networkConfig
+	Wireless         wirelessConfig
+	Address          addressConfig
+	Quota            quotaConfig
+	Disk             diskConfig
+	DiskIO           diskIOConfig
+	Temperature      temperatureConfig
+	Clock            clockConfig
+	Weather          weatherConfig
 }
 
 var (
@@ -45,8 +61,9 @@ var (
 
 func usage(filename string) {
 	fmt.Printf("Config file '%s' does not seem to exist. Please double check.\n", filename)
-	fmt.Printf("Usage: %s [config file]\n\n", app)
+	fmt.Printf("Usage: %s [--once | print] [config file]\n\n", app)
 	fmt.Printf("If no config file is specified, %s will try to use '$XDG_CONFIG_HOME/foobar/foobar.cfg', if $XDG_CONFIG_HOME is set, or '~/.config/foobar/foobar.cfg', otherwise.\n", app)
+	fmt.Printf("With --once, or print, the status is printed a single time, and %s exits.\n", app)
 	os.Exit(1)
 }
 
@@ -64,6 +81,34 @@ func configDirectory() string {
 	return baseDir
 }
 
+func stateDirectory() string {
+	// From XDG Base Directory Specification
+	//
+	// $XDG_STATE_HOME defines the base directory relative to which user-specific
+	// state files should be stored. If $XDG_STATE_HOME is either not set or empty,
+	// a default equal to $HOME/.local/state should be used.
+	baseDir := os.Getenv("XDG_STATE_HOME")
+	if len(baseDir) == 0 {
+		currentUser, _ := user.Current()
+		baseDir = fmt.Sprintf("%s/.local/state", currentUser.HomeDir)
+	}
+	return fmt.Sprintf("%s/%s", baseDir, app)
+}
+
+func cacheDirectory() string {
+	// From XDG Base Directory Specification
+	//
+	// $XDG_CACHE_HOME defines the base directory relative to which user-specific
+	// non-essential data files should be stored. If $XDG_CACHE_HOME is either not
+	// set or empty, a default equal to $HOME/.cache should be used.
+	baseDir := os.Getenv("XDG_CACHE_HOME")
+	if len(baseDir) == 0 {
+		currentUser, _ := user.Current()
+		baseDir = fmt.Sprintf("%s/.cache", currentUser.HomeDir)
+	}
+	return fmt.Sprintf("%s/%s", baseDir, app)
+}
+
 func defaultConfigFile() string {
 	return fmt.Sprintf("%s/%s/foobar.cfg", configDirectory(), app)
 }
@@ -88,12 +133,12 @@ func loadConfig() {
 	updateDzenConfig()
 
 	icons = make(map[string]string)
-	loadDzenColorFormats()
 
 	for i := range config.Icons {
 		icons[config.Icons[i].Name] = config.Icons[i].Icon
 	}
 
-	validSoundDevice = isValidSoundDevice()
+	loadModules()
+
 	username = os.Getenv("USER")
 }
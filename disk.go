@@ -0,0 +1,249 @@
+// Copyright 2017 Sergio Correia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// The default usage, in percentage, above which a filesystem makes
+	// the disk module urgent.
+	defaultDiskThreshold = 90
+
+	// /proc/diskstats always counts 512-byte sectors, regardless of
+	// the actual sector size of the device.
+	diskstatsSectorSize = 512
+)
+
+type diskConfig struct {
+	// Mounts lists the mount points to display. If empty, only the
+	// root filesystem is shown.
+	Mounts []string
+	// Free shows the free space, rather than a usage bar.
+	Free bool
+	// Threshold is the usage, in percentage, above which the module
+	// becomes urgent.
+	Threshold int
+}
+
+type diskIOConfig struct {
+	// Devices lists the block devices to display, such as "nvme0n1".
+	// If empty, every disk in /sys/block is shown, except virtual
+	// ones like loop and device mapper devices.
+	Devices []string
+}
+
+// diskIOCounters holds the rates of a block device.
+type diskIOCounters struct {
+	read  rateCounter
+	write rateCounter
+}
+
+// diskIOCollector reports the read and write throughput of block
+// devices.
+type diskIOCollector struct {
+	counters map[string]*diskIOCounters
+}
+
+var (
+	// Prefixes of block devices that are not backed by a disk of their
+	// own, and would be accounted twice.
+	virtualDiskPrefixes = []string{"loop", "ram", "zram", "dm-", "md", "sr", "fd", "nbd"}
+)
+
+// diskIODevices returns the block devices to display.
+func diskIODevices() []string {
+	if len(config.DiskIO.Devices) > 0 {
+		return config.DiskIO.Devices
+	}
+
+	entries, err := ioutil.ReadDir("/sys/block")
+	if err != nil {
+		return nil
+	}
+
+	var devices []string
+	for _, entry := range entries {
+		virtual := false
+		for _, prefix := range virtualDiskPrefixes {
+			if strings.HasPrefix(entry.Name(), prefix) {
+				virtual = true
+				break
+			}
+		}
+		if !virtual {
+			devices = append(devices, entry.Name())
+		}
+	}
+	return devices
+}
+
+// readDiskstats returns the sectors read and written by each block
+// device, as reported by /proc/diskstats.
+func readDiskstats() (map[string][2]uint64, error) {
+	file, err := os.Open("/proc/diskstats")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	sectors := make(map[string][2]uint64)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// 259 0 nvme0n1 59512 17 4160954 9622 111223 64371 5963480 89045 0 ...
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 {
+			continue
+		}
+
+		read, err := strconv.ParseUint(fields[5], 10, 64)
+		if err != nil {
+			continue
+		}
+		written, err := strconv.ParseUint(fields[9], 10, 64)
+		if err != nil {
+			continue
+		}
+		sectors[fields[2]] = [2]uint64{read, written}
+	}
+	return sectors, scanner.Err()
+}
+
+// diskUsage returns the used percentage of a filesystem, as df(1)
+// computes it, and the space available to unprivileged users.
+func diskUsage(mount string) (int, int, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(mount, &stat); err != nil {
+		return 0, 0, err
+	}
+
+	used := stat.Blocks - stat.Bfree
+	if used+stat.Bavail == 0 {
+		return 0, 0, fmt.Errorf("%s reports no blocks", mount)
+	}
+
+	percent := int(used * 100 / (used + stat.Bavail))
+	free := int(stat.Bavail * uint64(stat.Bsize))
+	return percent, free, nil
+}
+
+func collectDisk(key string) {
+	mounts := config.Disk.Mounts
+	if len(mounts) == 0 {
+		mounts = []string{"/"}
+	}
+
+	threshold := config.Disk.Threshold
+	if threshold <= 0 {
+		threshold = defaultDiskThreshold
+	}
+
+	var values []string
+	format := formatDefault
+	for _, mount := range mounts {
+		percent, free, err := diskUsage(mount)
+		if err != nil {
+			continue
+		}
+
+		if percent >= threshold {
+			format = formatUrgent
+		}
+
+		if config.Disk.Free {
+			values = append(values, fmt.Sprintf("%s %s", mount, strings.TrimSpace(formatBytes(free))))
+		} else {
+			values = append(values, fmt.Sprintf("%s %s", mount, progressBar(percent)))
+		}
+	}
+
+	if len(values) == 0 {
+		removeKey(key)
+		return
+	}
+
+	formatData(key, strings.Join(values, " "), icons[key], format)
+}
+
+func (d *diskIOCollector) Name() string {
+	return "diskio"
+}
+
+func (d *diskIOCollector) Interval() time.Duration {
+	return 2 * time.Second
+}
+
+func (d *diskIOCollector) Collect() {
+	key := d.Name()
+
+	sectors, err := readDiskstats()
+	if err != nil {
+		removeKey(key)
+		return
+	}
+
+	if d.counters == nil {
+		d.counters = make(map[string]*diskIOCounters)
+	}
+
+	devices := diskIODevices()
+	t := time.Now()
+
+	var values []string
+	for _, device := range devices {
+		current, ok := sectors[device]
+		if !ok {
+			continue
+		}
+
+		counters, ok := d.counters[device]
+		if !ok {
+			// The sectors are kept in an unsigned long, which wraps
+			// around quickly on 32-bit kernels.
+			bits := kernelLongBits()
+			counters = &diskIOCounters{read: rateCounter{bits: bits}, write: rateCounter{bits: bits}}
+			d.counters[device] = counters
+		}
+
+		read := counters.read.update(current[0], t, 0) * diskstatsSectorSize
+		write := counters.write.update(current[1], t, 0) * diskstatsSectorSize
+
+		value := fmt.Sprintf("%s%s %s%s", icons["disk_read"], strings.TrimSpace(formatBytes(int(read))), icons["disk_write"], strings.TrimSpace(formatBytes(int(write))))
+		if len(devices) > 1 {
+			value = fmt.Sprintf("%s %s", device, value)
+		}
+		values = append(values, value)
+	}
+
+	if len(values) == 0 {
+		removeKey(key)
+		return
+	}
+
+	formatData(key, strings.Join(values, " "), icons[key], formatDefault)
+}
+
+func init() {
+	registerFunc("disk", 30*time.Second, collectDisk)
+	registerCollector(&diskIOCollector{})
+}
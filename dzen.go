@@ -56,19 +56,7 @@ type barConfig struct {
 	Position     string
 }
 
-type info struct {
-	icon      string
-	key       string
-	value     string
-	format    *string
-	formatted string
-	length    int
-}
-
 var (
-	formatDefault string
-	formatUrgent  string
-
 	mainbarWidth  = 500
 	leftBarWidth  = 0
 	barHeight     = 15
@@ -93,21 +81,40 @@ func initDzenBars() {
 
 }
 
-func loadDzenColorFormats() {
-	formatDefault = fmt.Sprintf("^fg(%s)%%s ^fg(%s)%%s", config.Colors.Key, config.Colors.Value)
-	formatUrgent = fmt.Sprintf("^fg(%s)%%s %%s", config.Colors.Urgent)
+// dzenEscape escapes text so that it is not taken as dzen markup.
+func dzenEscape(s string) string {
+	return strings.Replace(s, "^", "^^", -1)
+}
+
+// dzenSegment renders a segment with dzen markup.
+func dzenSegment(s segment) string {
+	content := fmt.Sprintf("^fg(%s)%s", s.fg, renderText(s.text, dzenEscape))
+	if len(s.icon) > 0 {
+		content = fmt.Sprintf("^fg(%s)%s %s", s.iconFg, s.icon, content)
+	}
+	if len(s.bg) > 0 {
+		content = fmt.Sprintf("^bg(%s)%s^bg()", s.bg, content)
+	}
+	if len(s.action) > 0 {
+		content = fmt.Sprintf("^ca(1,%s)%s^ca()", s.action, content)
+	}
+	return content
 }
 
 func barWidthFromKey(key string) int {
 	w := 0
 	started := false
-	for i := range keys {
-		if keys[i] == key {
+	for _, c := range collectors {
+		if c.side != "right" {
+			continue
+		}
+
+		if c.Name() == key {
 			started = true
 		}
 
 		if started {
-			w += data[keys[i]].length
+			w += data[c.Name()].length
 		}
 
 	}
@@ -118,10 +125,24 @@ func barWidthFromKey(key string) int {
 }
 
 func leftBarContent(screen int) string {
-	if len(config.Popups.Info) > 0 {
-		return fmt.Sprintf("^ca(1,%s %d %d %d)^fg(%s)^bg(%s)  info^fg(%s)^bg(%s)  ^ca()\n", config.Popups.Info, (screen + 1), monitors[screen].width, monitors[screen].height, config.Colors.SidebarsFg, config.Colors.SidebarsBg, config.Colors.SidebarsBg, config.Colors.Bg)
+	// Modules configured to be displayed on the left side.
+	modules := ""
+	for _, s := range moduleSegments("left", screen) {
+		modules = fmt.Sprintf("%s %s", modules, dzenSegment(s))
+	}
+
+	return fmt.Sprintf("%s  %s\n", dzenSegment(labelSegment(screen)), modules)
+}
+
+// hasLeftModules reports whether any module is displayed in the left
+// bar, in which case it needs to be updated along with the main bar.
+func hasLeftModules() bool {
+	for _, c := range collectors {
+		if c.side == "left" {
+			return true
+		}
 	}
-	return fmt.Sprintf("^fg(%s)^bg(%s)  info^fg(%s)^bg(%s)  \n", config.Colors.SidebarsFg, config.Colors.SidebarsBg, config.Colors.SidebarsBg, config.Colors.Bg)
+	return false
 }
 
 func statusBarLen() int {
@@ -129,10 +150,13 @@ func statusBarLen() int {
 	var key string
 
 	// Regular bar without any color formatting.
-	for i := range keys {
-		key = keys[i]
+	for _, c := range collectors {
+		if c.side != "right" {
+			continue
+		}
+		key = c.Name()
 		if collected, ok := data[key]; ok {
-			bar = fmt.Sprintf("%s %s %s", bar, collected.icon, collected.value)
+			bar = fmt.Sprintf("%s %s %s", bar, collected.icon, renderText(collected.value, nil))
 		}
 	}
 
@@ -141,42 +165,63 @@ func statusBarLen() int {
 
 func statusBar(screen int) string {
 	bar := ""
-	var key string
-
-	for i := range keys {
-		key = keys[i]
-		if collected, ok := data[key]; ok {
-			switch {
-			case key == "clock" && len(config.Popups.Clock) > 0:
-				barWidth := barWidthFromKey(key)
-				bar = fmt.Sprintf("%s ^ca(1,%s %d %d %d %d)%s^ca()", bar, config.Popups.Clock, (screen + 1), monitors[screen].width, monitors[screen].height, barWidth, collected.formatted)
-			case key == "weather" && len(config.Popups.Weather) > 0:
-				barWidth := barWidthFromKey(key)
-				bar = fmt.Sprintf("%s ^ca(1,%s %d %d %d %d)%s^ca()", bar, config.Popups.Weather, (screen + 1), monitors[screen].width, monitors[screen].height, barWidth, collected.formatted)
-			default:
-				bar = fmt.Sprintf("%s %s", bar, collected.formatted)
-			}
-		}
-	}
-	if len(config.Popups.User) > 0 {
-		bar = fmt.Sprintf("%s ^ca(1,%s %d %d %d)^fg(%s)^fg(%s)^bg(%s) %s ^ca()", bar, config.Popups.User, (screen + 1), monitors[screen].width, monitors[screen].height, config.Colors.SidebarsBg, config.Colors.SidebarsFg, config.Colors.SidebarsBg, username)
-	} else {
-		bar = fmt.Sprintf("%s ^fg(%s)^fg(%s)^bg(%s) %s ", bar, config.Colors.SidebarsBg, config.Colors.SidebarsFg, config.Colors.SidebarsBg, username)
+	for _, s := range moduleSegments("right", screen) {
+		bar = fmt.Sprintf("%s %s", bar, dzenSegment(s))
 	}
-
-	resizeDzenMainBar()
+	bar = fmt.Sprintf("%s %s", bar, dzenSegment(userSegment(screen)))
 	return bar
 }
 
-func updateStatusBar() {
-	collectStats()
+// dzenBackend displays the status bar with dzen2, with a left and a
+// main bar on each monitor.
+type dzenBackend struct{}
+
+func (d *dzenBackend) start() {
+	// Information on the number of monitors.
+	getScreensInfo()
+
+	initDzenBars()
+}
+
+func (d *dzenBackend) draw() {
+	drawDzenBars()
+}
+
+func (d *dzenBackend) status() string {
+	return statusBar(0)
+}
+
+func (d *dzenBackend) update() {
+	resizeDzenMainBar()
 
 	var err error
 	status := ""
 	for i := 0; i < len(dzenMainbar); i++ {
 		status = fmt.Sprintf("%s\n", statusBar(i))
 		if _, err = io.WriteString(dzenMainbar[i].stdin, status); err != nil {
-			log.Printf("updateStatusBar: WriteString (bar #%d, status: %s) failed: %v", i, strings.Trim(status, "\n"), err)
+			log.Printf("dzenBackend.update: WriteString (bar #%d, status: %s) failed: %v", i, strings.Trim(status, "\n"), err)
+		}
+	}
+
+	updateLeftBar()
+}
+
+// updateLeftBar refreshes the left bar contents, if it displays any
+// module.
+func updateLeftBar() {
+	if !hasLeftModules() {
+		return
+	}
+
+	var err error
+	status := ""
+	for i := 0; i < len(dzenLeftbar); i++ {
+		if dzenLeftbar[i].hidden || dzenLeftbar[i].stdin == nil {
+			continue
+		}
+		status = leftBarContent(i)
+		if _, err = io.WriteString(dzenLeftbar[i].stdin, status); err != nil {
+			log.Printf("updateLeftBar: WriteString (bar #%d, status: %s) failed: %v", i, strings.Trim(status, "\n"), err)
 		}
 	}
 }
@@ -192,20 +237,6 @@ func resizeDzenMainBar() {
 	}
 }
 
-func reloadStatusBar() {
-	collectVolume("volume")
-	collectBrightness("brightness")
-
-	var err error
-	status := ""
-	for i := 0; i < len(monitors); i++ {
-		status = fmt.Sprintf("%s\n", statusBar(i))
-		if _, err = io.WriteString(dzenMainbar[i].stdin, status); err != nil {
-			log.Printf("reloadStatusBar: WriteString (bar #%d, status: %s) failed: %v", i, strings.Trim(status, "\n"), err)
-		}
-	}
-}
-
 func execDzen(args []string) (dzen *exec.Cmd, stdin io.WriteCloser, err error) {
 	dzen = exec.Command("dzen2", args...)
 	stdin, err = dzen.StdinPipe()
@@ -391,6 +422,10 @@ func updateDzenConfig() {
 }
 
 func toggleBars(monitor int) {
+	if monitor < 0 || monitor >= len(dzenLeftbar) {
+		return
+	}
+
 	hidden := dzenLeftbar[monitor].hidden
 
 	// close/drawDzenByMonitor take care of their hidden status.
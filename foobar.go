@@ -29,67 +29,92 @@ const (
 )
 
 func main() {
-	fmt.Printf("%s v%s\nCopyright (C) 2017 by %s\n", app, version, author)
+	// Standard output may be used to talk to the bar, depending on the
+	// output backend, so diagnostics go to stderr.
+	os.Stdout = os.Stderr
+
+	// In once mode, the status is printed a single time, e.g. for
+	// shell prompts, and the banner would only get in the way.
+	args := os.Args[1:]
+	onceMode = len(args) > 0 && (args[0] == "--once" || args[0] == "print")
+	if onceMode {
+		args = args[1:]
+	} else {
+		fmt.Printf("%s v%s\nCopyright (C) 2017 by %s\n", app, version, author)
+	}
 
 	configFile = defaultConfigFile()
-	if len(os.Args) > 1 {
-		configFile = os.Args[1]
+	if len(args) > 0 {
+		configFile = args[0]
 	}
 
 	if _, err := os.Stat(configFile); os.IsNotExist(err) {
 		usage(configFile)
 	}
 
-	// Information on the number of monitors.
-	getScreensInfo()
-
-	initDzenBars()
-
 	data = make(map[string]info)
 	loadConfig()
 
+	activeBackend = selectBackend()
+	if onceMode {
+		printOnce()
+		return
+	}
+	activeBackend.start()
+
 	// Bidirectional communication with WM via Unix domain socket.
 	go initiateWmCommunication()
 
-	network = networkInfo{validDevice: isValidNetDevice(), rxOld: 0, rxUpdateTime: 1, txOld: 0, txUpdateTime: 1}
-
 	signalChan := make(chan os.Signal, 1)
-	go func() {
-		for {
-			s := <-signalChan
-			switch s {
-			case syscall.SIGHUP:
-				fmt.Println("Reloading config...")
-				loadConfig()
-
-				// Reformatting info with possibly a new color theme.
-				updateFormatting()
-
-				triggerWmReload()
-
-				collectStats()
-				drawDzenBars()
-
-				updateStatusBar()
-			case syscall.SIGUSR1:
-				// Trigger a reload of the bar, to update info
-				// like the volume or brightness indicator.
-				reloadStatusBar()
-			default:
-				fmt.Println(s)
-			}
-		}
-	}()
-
 	signal.Notify(signalChan, syscall.SIGHUP, syscall.SIGUSR1)
 
-	drawDzenBars()
+	activeBackend.draw()
 
 	for {
-		updateStatusBar()
+		startWatchers()
+
+		select {
+		case <-time.After(time.Until(nextCollection())):
+			// Some module is due.
+			updateStatusBar()
+		case name := <-refreshChan:
+			// A module was notified of a change.
+			reloadStatusBar(name)
+		case s := <-signalChan:
+			handleSignal(s)
+		}
+	}
+}
+
+func handleSignal(s os.Signal) {
+	switch s {
+	case syscall.SIGHUP:
+		fmt.Println("Reloading config...")
+		loadConfig()
+
+		triggerWmReload()
+
+		collectStats()
+		activeBackend.draw()
 
-		// Sleep until the beginning of next second.
-		var now = time.Now()
-		time.Sleep(now.Truncate(time.Second).Add(time.Second).Sub(now))
+		updateStatusBar()
+	case syscall.SIGUSR1:
+		// Trigger a reload of the bar, to update info
+		// like the volume or brightness indicator.
+		reloadStatusBar("volume", "brightness")
+	default:
+		fmt.Println(s)
 	}
 }
+
+// printOnce runs every module a single time and prints the resulting
+// status line, without starting the bar, the watchers or the
+// communication with the WM. With a single sample, cpu reports the
+// utilisation since boot, and modules that report rates, such as rx,
+// tx or diskio, show no activity. Weather is fetched before printing,
+// so it may take up to weatherTimeout.
+func printOnce() {
+	collectStats()
+
+	fmt.Fprintln(stdout, activeBackend.status())
+}
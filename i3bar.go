@@ -0,0 +1,170 @@
+// Copyright 2017 Sergio Correia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// i3barHeader starts the i3bar protocol, as described in
+// https://i3wm.org/docs/i3bar-protocol.html.
+type i3barHeader struct {
+	Version     int  `json:"version"`
+	ClickEvents bool `json:"click_events"`
+}
+
+// i3barBlock is an entry of the status line.
+type i3barBlock struct {
+	Name       string `json:"name"`
+	FullText   string `json:"full_text"`
+	Color      string `json:"color,omitempty"`
+	Background string `json:"background,omitempty"`
+	Urgent     bool   `json:"urgent,omitempty"`
+}
+
+// i3barClick is sent by the bar, on stdin, when a block is clicked.
+type i3barClick struct {
+	Name   string `json:"name"`
+	Button int    `json:"button"`
+	X      int    `json:"x"`
+	Y      int    `json:"y"`
+}
+
+// i3barBackend writes the status line to stdout, following the
+// i3bar protocol, which is also spoken by swaybar.
+type i3barBackend struct {
+	// popups holds the popup of each block, if any, for the click
+	// events, which are read in their own goroutine.
+	mu     sync.Mutex
+	popups map[string]string
+}
+
+func (b *i3barBackend) start() {
+	header, err := json.Marshal(i3barHeader{Version: 1, ClickEvents: true})
+	if err != nil {
+		log.Printf("i3barBackend.start: header marshalling failed: %v", err)
+		return
+	}
+
+	// The status lines follow as an endless array.
+	fmt.Fprintf(stdout, "%s\n[\n", header)
+
+	go b.readClicks()
+}
+
+func (b *i3barBackend) draw() {
+	b.update()
+}
+
+func (b *i3barBackend) status() string {
+	status, err := json.Marshal(b.blocks())
+	if err != nil {
+		log.Printf("i3barBackend.status: status marshalling failed: %v", err)
+		return ""
+	}
+	return string(status)
+}
+
+// blocks returns the blocks of the status line, and records their
+// popups for the click events.
+func (b *i3barBackend) blocks() []i3barBlock {
+	// i3bar is not tied to a monitor, and has a single status line,
+	// so the modules displayed in the left bar come first.
+	segments := append(moduleSegments("left", -1), moduleSegments("right", -1)...)
+	segments = append(segments, userSegment(-1))
+
+	var blocks []i3barBlock
+	popups := make(map[string]string)
+	for _, s := range segments {
+		blocks = append(blocks, i3barBlockFromSegment(s))
+		if len(s.action) > 0 {
+			popups[s.name] = s.action
+		}
+	}
+
+	b.mu.Lock()
+	b.popups = popups
+	b.mu.Unlock()
+
+	return blocks
+}
+
+func (b *i3barBackend) update() {
+	status := b.status()
+	if len(status) == 0 {
+		return
+	}
+
+	if _, err := fmt.Fprintf(stdout, "%s,\n", status); err != nil {
+		log.Printf("i3barBackend.update: write (status: %s) failed: %v", status, err)
+	}
+}
+
+// i3barBlockFromSegment returns the block of a segment. Blocks have a
+// single color, so icons take the color of the text.
+func i3barBlockFromSegment(s segment) i3barBlock {
+	text := renderText(s.text, nil)
+	if len(s.icon) > 0 {
+		text = fmt.Sprintf("%s %s", s.icon, text)
+	}
+	return i3barBlock{Name: s.name, FullText: text, Color: s.fg, Background: s.bg, Urgent: s.urgent}
+}
+
+// popup returns the popup of a block, if any.
+func (b *i3barBackend) popup(name string) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.popups[name]
+}
+
+// readClicks handles the click events sent by the bar. Clicking a
+// block refreshes its module, and a left click also opens its popup,
+// if configured. As the bar does not tell which monitor it is on, the
+// popups receive the position of the click instead.
+func (b *i3barBackend) readClicks() {
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		// The events are elements of an endless array, so each line
+		// may start with either "[" or ",".
+		line := strings.TrimLeft(strings.TrimSpace(scanner.Text()), "[,")
+		if len(line) == 0 {
+			continue
+		}
+
+		var click i3barClick
+		if err := json.Unmarshal([]byte(line), &click); err != nil {
+			log.Printf("i3barBackend.readClicks: invalid click event (%s): %v", line, err)
+			continue
+		}
+
+		requestRefresh(click.Name)
+
+		if popup := b.popup(click.Name); click.Button == 1 && len(popup) > 0 {
+			cmd := exec.Command("sh", "-c", fmt.Sprintf("%s %d %d", popup, click.X, click.Y))
+			if err := cmd.Start(); err != nil {
+				log.Printf("i3barBackend.readClicks: popup '%s' failed: %v", popup, err)
+				continue
+			}
+			go cmd.Wait()
+		}
+	}
+}
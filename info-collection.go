@@ -16,78 +16,49 @@ package main
 import (
 	"bufio"
 	"fmt"
-	"io/ioutil"
-	"math/rand"
 	"os"
-	"os/exec"
-	"regexp"
-	"runtime"
 	"strconv"
 	"strings"
 	"time"
 )
 
-type networkInfo struct {
-	validDevice  bool
-	rxOld        int
-	rxUpdateTime int
-	txOld        int
-	txUpdateTime int
-}
-
-var (
-	data map[string]info
-
-	network networkInfo
-	keys    = []string{"clock", "rx", "tx", "volume", "battery", "brightness", "cpu", "ram"}
-
-	validSoundDevice = false
-	cores            = runtime.NumCPU()
+const (
+	// The default "full" memory pressure, in percentage of stalled
+	// time, above which the ram module becomes urgent.
+	defaultPressureThreshold = 10.0
 )
 
-func isValidNetDevice() bool {
-	out, err := exec.Command("ip", "addr", "show", config.NetworkInterface).Output()
-	if err != nil || len(out) == 0 {
-		removeKey("rx")
-		removeKey("tx")
-		fmt.Printf("Network device '%s' is not valid; please recheck the config file\n", config.NetworkInterface)
-		return false
-	}
-
-	return true
+type cpuConfig struct {
+	// PerCore also shows the utilisation of each core.
+	PerCore bool
+	// Breakdown also shows the iowait and steal percentages.
+	Breakdown bool
 }
 
-func isValidSoundDevice() bool {
-	out, err := exec.Command("pactl", "list", "sinks", "short").Output()
-	if err != nil || len(out) == 0 {
-		fmt.Printf("Sound device '%s' is not valid; please recheck the config file\n", config.SoundDevice)
-		removeKey("volume")
-		return false
-	}
-
-	scanner := bufio.NewScanner(strings.NewReader(string(out)))
-	for scanner.Scan() {
-		deviceID := strings.Split(scanner.Text(), "\t")[0]
-		if deviceID == config.SoundDevice {
-			return true
-		}
-	}
-	removeKey("volume")
-	fmt.Printf("Sound device '%s' is not valid; please recheck the config file\n", config.SoundDevice)
-	return false
+type ramConfig struct {
+	// Swap also shows the swap usage, if there is any swap.
+	Swap bool
+	// Pressure also shows the memory pressure, and switches the
+	// module to urgent when the "full" stall percentage reaches
+	// PressureThreshold.
+	Pressure          bool
+	PressureThreshold float64
 }
 
-func formatData(key, value, icon string, format *string) {
-	data[key] = info{icon: icon, key: key, value: value, format: format, formatted: fmt.Sprintf(*format, icon, value), length: len(value) + 2}
+type info struct {
+	icon   string
+	key    string
+	value  string
+	format infoFormat
+	length int
 }
 
-func updateFormatting() {
-	for i := 0; i < len(keys); i++ {
-		key := keys[i]
-		if current, ok := data[key]; ok {
-			formatData(key, current.value, current.icon, current.format)
-		}
-	}
+var (
+	data map[string]info
+)
+
+func formatData(key, value, icon string, format infoFormat) {
+	data[key] = info{icon: icon, key: key, value: value, format: format, length: len(renderText(value, nil)) + 2}
 }
 
 func removeKey(key string) {
@@ -96,130 +67,33 @@ func removeKey(key string) {
 	}
 }
 
-func collectTime(key string) {
-	t := time.Now()
-
-	formatData(key, t.Format("15:04:05"), icons[key], &formatDefault)
-}
-
-func collectNetwork(rxkey, txkey string) {
-	if !network.validDevice {
-		return
-	}
-
-	// Based in https://github.com/schachmat/gods/blob/master/gods.go
-	file, err := os.Open("/proc/net/dev")
+// memoryPressure returns the share of time, over the last 10 seconds,
+// in which some and all tasks were stalled waiting for memory, as
+// reported by PSI in /proc/pressure/memory.
+func memoryPressure() (some, full float64, err error) {
+	file, err := os.Open("/proc/pressure/memory")
 	if err != nil {
-		removeKey(rxkey)
-		removeKey(txkey)
-		return
+		return 0, 0, err
 	}
 	defer file.Close()
 
-	var void = 0 // target for unused values
-	var dev, rx, tx, rxNow, txNow = "", 0, 0, 0, 0
-	var scanner = bufio.NewScanner(file)
+	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
-		_, err = fmt.Sscanf(scanner.Text(), "%s %d %d %d %d %d %d %d %d %d",
-			&dev, &rx, &void, &void, &void, &void, &void, &void, &void, &tx)
-
-		if dev[:len(dev)-1] == config.NetworkInterface {
-			rxNow += rx
-			txNow += tx
+		// some avg10=0.00 avg60=0.00 avg300=0.00 total=0
+		var kind string
+		var avg10 float64
+		if _, err = fmt.Sscanf(scanner.Text(), "%s avg10=%f", &kind, &avg10); err != nil {
+			return 0, 0, err
 		}
-	}
-
-	defer func() { network.rxOld, network.txOld = rxNow, txNow }()
-	rxdata := fmt.Sprintf("%s", formatBytes(rxNow-network.rxOld))
-	txdata := fmt.Sprintf("%s", formatBytes(txNow-network.txOld))
-
-	network.rxUpdateTime--
-	if network.rxUpdateTime <= 0 {
-		formatData(rxkey, rxdata, icons[rxkey], &formatDefault)
-		network.rxUpdateTime = rand.Intn(2) + 1
-	}
-
-	network.txUpdateTime--
-	if network.txUpdateTime <= 0 {
-		formatData(txkey, txdata, icons[txkey], &formatDefault)
-		network.txUpdateTime = rand.Intn(2) + 1
-	}
-}
-
-func collectBrightness(key string) {
-	actual, err := ioutil.ReadFile("/sys/class/backlight/intel_backlight/actual_brightness")
-	if err != nil {
-		removeKey(key)
-		return
-	}
-
-	max, err := ioutil.ReadFile("/sys/class/backlight/intel_backlight/max_brightness")
-	if err != nil {
-		removeKey(key)
-		return
-	}
-
-	var actualBr, maxBr int
-	_, err = fmt.Sscanf(string(actual), "%d", &actualBr)
-	if err != nil {
-		removeKey(key)
-		return
-	}
-	_, err = fmt.Sscanf(string(max), "%d", &maxBr)
-	if err != nil {
-		removeKey(key)
-		return
-	}
-
-	cur := 100 * actualBr / maxBr
-	formatData(key, progressBar(cur), icons[key], &formatDefault)
-}
-
-func collectPower(key string) {
-	out, err := exec.Command("acpi", "-b").Output()
-	if err != nil || len(out) == 0 {
-		removeKey(key)
-		return
-	}
-
-	output := string(out)
-
-	split := strings.Split(output, " ")
-	charge := split[3][:len(split[3])-1]
 
-	var value int
-	var icon string
-	_, err = fmt.Sscanf(charge, "%d", &value)
-	if err != nil {
-		removeKey(key)
-		return
-	}
-
-	var iconName string
-	switch {
-	case value > 75:
-		iconName = "battery_full"
-	case value > 50:
-		iconName = "battery_three_quarters"
-	case value > 25:
-		iconName = "battery_half"
-	case value > 10:
-		iconName = "battery_quarter"
-	default:
-		iconName = "battery_empty"
-	}
-
-	if strings.Contains(output, "Charging") || strings.Contains(output, "will never fully discharge") {
-		iconName = fmt.Sprintf("%s_power", iconName)
-	}
-	icon = icons[iconName]
-
-	format := &formatDefault
-	if value <= 10 {
-		format = &formatUrgent
+		switch kind {
+		case "some":
+			some = avg10
+		case "full":
+			full = avg10
+		}
 	}
-
-	formatData(key, progressBar(value), icon, format)
+	return some, full, scanner.Err()
 }
 
 func collectRAM(key string) {
@@ -232,7 +106,7 @@ func collectRAM(key string) {
 	defer file.Close()
 
 	// done must equal the flag combination (0001 | 0010 | 0100 | 1000) = 15
-	var total, used, done = 0, 0, 0
+	var total, available, swapTotal, swapFree, done = 0, 0, 0, 0, 0
 	for info := bufio.NewScanner(file); done != 15 && info.Scan(); {
 		var prop, val = "", 0
 		if _, err = fmt.Sscanf(info.Text(), "%s %d", &prop, &val); err != nil {
@@ -243,130 +117,177 @@ func collectRAM(key string) {
 		switch prop {
 		case "MemTotal:":
 			total = val
-			used += val
 			done |= 1
-		case "MemFree:":
-			used -= val
+		case "MemAvailable:":
+			available = val
 			done |= 2
-		case "Buffers:":
-			used -= val
+		case "SwapTotal:":
+			swapTotal = val
 			done |= 4
-		case "Cached:":
-			used -= val
+		case "SwapFree:":
+			swapFree = val
 			done |= 8
 		}
 	}
 
-	ram := used * 100 / total
-	formatData(key, progressBar(ram), icons[key], &formatDefault)
-}
-
-func collectCPU(key string) {
-	// from: https://github.com/schachmat/gods/blob/master/gods.go
-	var load float32
-	loadavg, err := ioutil.ReadFile("/proc/loadavg")
-	if err != nil {
+	// MemAvailable exists since Linux 3.14.
+	if done&3 != 3 || total == 0 {
 		removeKey(key)
 		return
 	}
 
-	_, err = fmt.Sscanf(string(loadavg), "%f", &load)
-	if err != nil {
-		removeKey(key)
-		return
+	ram := (total - available) * 100 / total
+	value := progressBar(ram)
+	format := formatDefault
+
+	if config.RAM.Swap && swapTotal > 0 {
+		swap := (swapTotal - swapFree) * 100 / swapTotal
+		value = fmt.Sprintf("%s %s %s", value, icons["swap"], progressBar(swap))
 	}
 
-	cpu := int(load * 100.0 / float32(cores))
-	formatData(key, progressBar(cpu), icons[key], &formatDefault)
-}
+	if config.RAM.Pressure {
+		if some, full, err := memoryPressure(); err == nil {
+			value = fmt.Sprintf("%s psi:%.0f%%", value, some)
 
-func collectVolume(key string) {
-	if !validSoundDevice {
-		return
+			threshold := config.RAM.PressureThreshold
+			if threshold <= 0 {
+				threshold = defaultPressureThreshold
+			}
+			// A high "full" stall means the system is thrashing.
+			if full >= threshold {
+				format = formatUrgent
+			}
+		}
 	}
 
-	deviceID, err := strconv.Atoi(config.SoundDevice)
-	if err != nil {
-		removeKey(key)
-		return
-	}
+	formatData(key, value, icons[key], format)
+}
 
-	out, err := exec.Command("pactl", "list", "sinks").Output()
-	if err != nil {
-		removeKey(key)
-		return
-	}
+// cpuTimes holds the jiffies spent by a CPU, as read from /proc/stat.
+type cpuTimes struct {
+	total  uint64
+	idle   uint64
+	iowait uint64
+	steal  uint64
+}
 
-	output := string(out)
-	var trimmed string
-	volumes := make([]string, deviceID+1, deviceID+1)
-	muted := make([]bool, deviceID+1, deviceID+1)
-	headphone := make([]bool, deviceID+1, deviceID+1)
-	volumesIndex := 0
-	muteIndex := 0
-	headphoneIndex := 0
-	reInsideWhitespace := regexp.MustCompile(`[\s\p{Zs}]{2,}`)
+// cpuCollector reports the CPU utilisation between two samples of
+// /proc/stat.
+type cpuCollector struct {
+	prev map[string]cpuTimes
+}
 
-	scanner := bufio.NewScanner(strings.NewReader(output))
+func readCPUStat() ([]string, map[string]cpuTimes, error) {
+	file, err := os.Open("/proc/stat")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
 
+	var names []string
+	times := make(map[string]cpuTimes)
+	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
-		trimmed = reInsideWhitespace.ReplaceAllString(strings.TrimLeft(scanner.Text(), " \t\r\n"), " ")
-		if strings.HasPrefix(trimmed, "Mute: ") {
-			// Mute: no
-			muted[muteIndex] = (strings.Split(trimmed, " ")[1] == "yes")
-			muteIndex++
-		}
-		if strings.HasPrefix(trimmed, "Volume: ") {
-			// Volume: front-left: 43055 /  66% / -10.95 dB,   front-right: 43055 /  66% / -10.95 dB
-			volumes[volumesIndex] = strings.Split(trimmed, " ")[4]
-			volumesIndex++
+		// cpu0 4705 356 584 3699176 23060 0 277 0 0 0
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 || !strings.HasPrefix(fields[0], "cpu") {
+			continue
 		}
-		if strings.HasPrefix(trimmed, "Active Port: ") {
-			headphone[headphoneIndex] = strings.Contains(strings.Split(trimmed, " ")[2], "headphones")
+
+		var t cpuTimes
+		for i, field := range fields[1:] {
+			// guest and guest_nice are already accounted in user
+			// and nice.
+			if i >= 8 {
+				break
+			}
+			value, err := strconv.ParseUint(field, 10, 64)
+			if err != nil {
+				return nil, nil, err
+			}
+			t.total += value
+			switch i {
+			case 3:
+				t.idle = value
+			case 4:
+				t.iowait = value
+			case 7:
+				t.steal = value
+			}
 		}
+		names = append(names, fields[0])
+		times[fields[0]] = t
+	}
+	return names, times, scanner.Err()
+}
+
+// cpuPercent returns the busy, iowait and steal percentages between
+// two samples.
+func cpuPercent(prev, cur cpuTimes) (busy, iowait, steal int) {
+	if cur.total <= prev.total {
+		return 0, 0, 0
 	}
+	total := cur.total - prev.total
+	idle := jiffiesDelta(prev.idle+prev.iowait, cur.idle+cur.iowait)
+	if idle > total {
+		idle = total
+	}
+	busy = int((total - idle) * 100 / total)
+	iowait = int(jiffiesDelta(prev.iowait, cur.iowait) * 100 / total)
+	steal = int(jiffiesDelta(prev.steal, cur.steal) * 100 / total)
+	return busy, iowait, steal
+}
+
+// jiffiesDelta returns how much a /proc/stat counter increased. Some
+// of them, like iowait, are not monotonic, and may go backwards.
+func jiffiesDelta(prev, cur uint64) uint64 {
+	if cur < prev {
+		return 0
+	}
+	return cur - prev
+}
+
+func (c *cpuCollector) Name() string {
+	return "cpu"
+}
 
-	volume, err := strconv.Atoi(volumes[deviceID][:len(volumes[deviceID])-1])
+func (c *cpuCollector) Interval() time.Duration {
+	return 2 * time.Second
+}
+
+func (c *cpuCollector) Collect() {
+	key := c.Name()
+	names, times, err := readCPUStat()
 	if err != nil {
 		removeKey(key)
 		return
 	}
 
-	var icon string
-
-	format := &formatDefault
-	if muted[deviceID] {
-		format = &formatUrgent
-		if headphone[deviceID] {
-			icon = icons["headphone_mute"]
-		} else {
-			if volume > 40 {
-				icon = icons["volume_loud_mute"]
-			} else {
-				icon = icons["volume_low_mute"]
-			}
-		}
-	} else {
-		if headphone[deviceID] {
-			icon = icons["headphone"]
-		} else {
-			if volume > 40 {
-				icon = icons["volume_loud"]
-			} else {
-				icon = icons["volume_low"]
+	// On the first run, prev is empty and the utilisation since boot
+	// is reported.
+	defer func() { c.prev = times }()
+
+	busy, iowait, steal := cpuPercent(c.prev["cpu"], times["cpu"])
+	value := progressBar(busy)
+
+	if config.CPU.PerCore {
+		for _, name := range names {
+			if name == "cpu" {
+				continue
 			}
+			core, _, _ := cpuPercent(c.prev[name], times[name])
+			value = fmt.Sprintf("%s %s", value, progressBar(core))
 		}
 	}
 
-	formatData(key, progressBar(volume), icon, format)
+	if config.CPU.Breakdown {
+		value = fmt.Sprintf("%s wa:%d%% st:%d%%", value, iowait, steal)
+	}
+
+	formatData(key, value, icons[key], formatDefault)
 }
 
-func collectStats() {
-	collectVolume("volume")
-	collectTime("clock")
-	collectRAM("ram")
-	collectCPU("cpu")
-	collectNetwork("rx", "tx")
-	collectPower("battery")
-	collectBrightness("brightness")
+func init() {
+	registerCollector(&cpuCollector{})
+	registerFunc("ram", 5*time.Second, collectRAM)
 }
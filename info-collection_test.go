@@ -0,0 +1,81 @@
+// Copyright 2017 Sergio Correia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+)
+
+func TestCPUPercent(t *testing.T) {
+	tests := []struct {
+		name                string
+		prev, cur           cpuTimes
+		busy, iowait, steal int
+	}{
+		{
+			name: "idle",
+			prev: cpuTimes{total: 1000, idle: 500},
+			cur:  cpuTimes{total: 1100, idle: 600},
+		},
+		{
+			name: "busy",
+			prev: cpuTimes{total: 1000, idle: 500},
+			cur:  cpuTimes{total: 1100, idle: 525},
+			busy: 75,
+		},
+		{
+			name:   "iowait and steal",
+			prev:   cpuTimes{total: 1000, idle: 500, iowait: 100, steal: 10},
+			cur:    cpuTimes{total: 1100, idle: 540, iowait: 120, steal: 30},
+			busy:   40,
+			iowait: 20,
+			steal:  20,
+		},
+		{
+			name: "iowait goes backwards",
+			prev: cpuTimes{total: 1000, idle: 500, iowait: 100},
+			cur:  cpuTimes{total: 1100, idle: 560, iowait: 30},
+			busy: 100,
+		},
+		{
+			name: "steal goes backwards",
+			prev: cpuTimes{total: 1000, idle: 500, steal: 50},
+			cur:  cpuTimes{total: 1100, idle: 550, steal: 40},
+			busy: 50,
+		},
+		{
+			name: "idle exceeds total",
+			prev: cpuTimes{total: 1000, idle: 500},
+			cur:  cpuTimes{total: 1100, idle: 700},
+		},
+		{
+			name: "no time elapsed",
+			prev: cpuTimes{total: 1000, idle: 500},
+			cur:  cpuTimes{total: 1000, idle: 500},
+		},
+		{
+			name: "total goes backwards",
+			prev: cpuTimes{total: 1000, idle: 500},
+			cur:  cpuTimes{total: 900, idle: 400},
+		},
+	}
+
+	for _, test := range tests {
+		busy, iowait, steal := cpuPercent(test.prev, test.cur)
+		if busy != test.busy || iowait != test.iowait || steal != test.steal {
+			t.Errorf("%s: cpuPercent() = %d, %d, %d; want %d, %d, %d", test.name, busy, iowait, steal, test.busy, test.iowait, test.steal)
+		}
+	}
+}
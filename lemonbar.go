@@ -0,0 +1,164 @@
+// Copyright 2017 Sergio Correia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+const (
+	// Number of clickable areas lemonbar allocates; every monitor has
+	// up to four popups.
+	lemonbarClickableAreas = 32
+)
+
+// lemonbarBackend displays the status bar with a single lemonbar,
+// which spans every monitor.
+type lemonbarBackend struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+}
+
+// lemonbarEscape escapes text so that it is not taken as lemonbar
+// markup.
+func lemonbarEscape(s string) string {
+	return strings.Replace(s, "%", "%%", -1)
+}
+
+// lemonbarAction wraps content in a clickable area that runs command
+// when clicked with the left button.
+func lemonbarAction(command, content string) string {
+	// Colons delimit the command, so they have to be escaped.
+	command = strings.Replace(lemonbarEscape(command), ":", "\\:", -1)
+	return fmt.Sprintf("%%{A:%s:}%s%%{A}", command, content)
+}
+
+// lemonbarSegment renders a segment with lemonbar markup.
+func lemonbarSegment(s segment) string {
+	content := fmt.Sprintf("%%{F%s}%s", s.fg, renderText(s.text, lemonbarEscape))
+	if len(s.icon) > 0 {
+		content = fmt.Sprintf("%%{F%s}%s %s", s.iconFg, s.icon, content)
+	}
+	if len(s.bg) > 0 {
+		content = fmt.Sprintf("%%{B%s}%s%%{B-}", s.bg, content)
+	}
+	if len(s.action) > 0 {
+		content = lemonbarAction(s.action, content)
+	}
+	return content
+}
+
+// lemonbarContent returns the contents of the bar on a monitor, with
+// the left bar contents aligned to the left, and the main bar ones to
+// the right.
+func lemonbarContent(screen int) string {
+	left := lemonbarSegment(labelSegment(screen)) + " "
+	for _, s := range moduleSegments("left", screen) {
+		left = fmt.Sprintf("%s %s", left, lemonbarSegment(s))
+	}
+
+	right := ""
+	for _, s := range moduleSegments("right", screen) {
+		right = fmt.Sprintf("%s %s", right, lemonbarSegment(s))
+	}
+	right = fmt.Sprintf("%s %s", right, lemonbarSegment(userSegment(screen)))
+
+	return fmt.Sprintf("%%{S%d}%%{l}%s%%{r}%s", screen, left, right)
+}
+
+// runLemonbarActions runs the commands lemonbar prints when a
+// clickable area is clicked.
+func runLemonbarActions(actions io.Reader) {
+	scanner := bufio.NewScanner(actions)
+	for scanner.Scan() {
+		action := scanner.Text()
+		if len(action) == 0 {
+			continue
+		}
+
+		cmd := exec.Command("sh", "-c", action)
+		if err := cmd.Start(); err != nil {
+			log.Printf("runLemonbarActions: '%s' failed: %v", action, err)
+			continue
+		}
+		go cmd.Wait()
+	}
+}
+
+func (l *lemonbarBackend) start() {
+	// Information on the number of monitors.
+	getScreensInfo()
+}
+
+func (l *lemonbarBackend) draw() {
+	args := []string{"-g", fmt.Sprintf("x%d", barHeight), "-f", config.Font, "-B", config.Colors.Bg, "-F", config.Colors.Key, "-a", fmt.Sprintf("%d", lemonbarClickableAreas)}
+	if !isTopBar {
+		args = append(args, "-b")
+	}
+
+	cmd := exec.Command("lemonbar", args...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		log.Printf("lemonbarBackend.draw: StdinPipe failed: %v", err)
+		return
+	}
+	actions, err := cmd.StdoutPipe()
+	if err != nil {
+		log.Printf("lemonbarBackend.draw: StdoutPipe failed: %v", err)
+		return
+	}
+	cmd.Stderr = os.Stderr
+
+	if err = cmd.Start(); err != nil {
+		log.Printf("lemonbarBackend.draw: lemonbar (%v) failed: %v", args, err)
+		return
+	}
+	go runLemonbarActions(actions)
+
+	// Replace the previous bar, e.g. after the config is reloaded.
+	if l.cmd != nil {
+		l.stdin.Close()
+		go l.cmd.Wait()
+	}
+	l.cmd = cmd
+	l.stdin = stdin
+
+	l.update()
+}
+
+func (l *lemonbarBackend) status() string {
+	return lemonbarContent(0)
+}
+
+func (l *lemonbarBackend) update() {
+	if l.stdin == nil {
+		return
+	}
+
+	status := ""
+	for i := range monitors {
+		status += lemonbarContent(i)
+	}
+
+	if _, err := io.WriteString(l.stdin, status+"\n"); err != nil {
+		log.Printf("lemonbarBackend.update: WriteString (status: %s) failed: %v", status, err)
+	}
+}
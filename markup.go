@@ -0,0 +1,156 @@
+// Copyright 2017 Sergio Correia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// infoFormat tells how a collected entry should be displayed.
+type infoFormat int
+
+const (
+	formatDefault infoFormat = iota
+	formatUrgent
+)
+
+const (
+	// Untrusted text in values is enclosed in these markers, so that
+	// each backend escapes it, and only it, with its own rules. The
+	// rest of the values, such as the icons they embed, is kept as it
+	// is.
+	untrustedStart = "\x02"
+	untrustedEnd   = "\x03"
+)
+
+// segment is a piece of the status bar, independent of the markup of
+// any status bar program; each backend renders segments on its own.
+type segment struct {
+	// name is the module the segment displays, or either "info" or
+	// "user", for the labels at each end of the bar.
+	name string
+	// icon, if any, is displayed before text, with iconFg as its
+	// foreground color.
+	icon   string
+	iconFg string
+	text   string
+	fg     string
+	// bg is the background color; if empty, the bar background is
+	// used.
+	bg     string
+	urgent bool
+	// action is the command run when the segment is left-clicked.
+	action string
+}
+
+// untrusted marks text that neither the config file nor foobar
+// control, such as the name of a wireless network, so that backends
+// never take it as markup.
+func untrusted(s string) string {
+	s = strings.NewReplacer(untrustedStart, "", untrustedEnd, "").Replace(s)
+	return untrustedStart + s + untrustedEnd
+}
+
+// renderText returns text with its untrusted parts escaped by escape,
+// and without their markers. If escape is nil, they are kept as they
+// are, as for backends without markup.
+func renderText(text string, escape func(string) string) string {
+	var b strings.Builder
+	for {
+		start := strings.Index(text, untrustedStart)
+		if start < 0 {
+			b.WriteString(text)
+			return b.String()
+		}
+		b.WriteString(text[:start])
+		text = text[start+len(untrustedStart):]
+
+		end := strings.Index(text, untrustedEnd)
+		if end < 0 {
+			end = len(text)
+		}
+		if escape != nil {
+			b.WriteString(escape(text[:end]))
+		} else {
+			b.WriteString(text[:end])
+		}
+		text = strings.TrimPrefix(text[end:], untrustedEnd)
+	}
+}
+
+// popupAction returns the command that opens a popup on a monitor.
+// Popups receive the monitor number and size, followed by extra. If
+// screen is not a monitor, as for backends that are not tied to one,
+// only the popup is returned, and the backend adds its own arguments.
+func popupAction(popup string, screen int, extra ...int) string {
+	if len(popup) == 0 || screen < 0 || screen >= len(monitors) {
+		return popup
+	}
+
+	action := fmt.Sprintf("%s %d %d %d", popup, screen+1, monitors[screen].width, monitors[screen].height)
+	for _, e := range extra {
+		action = fmt.Sprintf("%s %d", action, e)
+	}
+	return action
+}
+
+// infoSegment returns the segment of a collected entry.
+func infoSegment(collected info) segment {
+	s := segment{name: collected.key, icon: collected.icon, iconFg: config.Colors.Key, text: collected.value, fg: config.Colors.Value}
+	if collected.format == formatUrgent {
+		s.iconFg = config.Colors.Urgent
+		s.fg = config.Colors.Urgent
+		s.urgent = true
+	}
+	return s
+}
+
+// moduleSegments returns the segments of the modules displayed on
+// one side of the bar, "left" or "right", on a monitor.
+func moduleSegments(side string, screen int) []segment {
+	var segments []segment
+	for _, c := range collectors {
+		if c.side != side {
+			continue
+		}
+
+		key := c.Name()
+		collected, ok := data[key]
+		if !ok {
+			continue
+		}
+
+		s := infoSegment(collected)
+		switch key {
+		case "clock":
+			s.action = popupAction(config.Popups.Clock, screen, barWidthFromKey(key))
+		case "weather":
+			s.action = popupAction(config.Popups.Weather, screen, barWidthFromKey(key))
+		}
+		segments = append(segments, s)
+	}
+	return segments
+}
+
+// labelSegment returns the "info" label that starts the left bar.
+func labelSegment(screen int) segment {
+	return segment{name: "info", text: "  info", fg: config.Colors.SidebarsFg, bg: config.Colors.SidebarsBg, action: popupAction(config.Popups.Info, screen)}
+}
+
+// userSegment returns the user name that ends the main bar.
+func userSegment(screen int) segment {
+	return segment{name: "user", text: fmt.Sprintf(" %s ", username), fg: config.Colors.SidebarsFg, bg: config.Colors.SidebarsBg, action: popupAction(config.Popups.User, screen)}
+}
@@ -0,0 +1,70 @@
+// Copyright 2017 Sergio Correia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+)
+
+func TestRenderText(t *testing.T) {
+	tests := []struct {
+		name   string
+		text   string
+		escape func(string) string
+		want   string
+	}{
+		{
+			name:   "no untrusted text",
+			text:   "^i(bar.xbm) 50%",
+			escape: dzenEscape,
+			want:   "^i(bar.xbm) 50%",
+		},
+		{
+			name:   "dzen",
+			text:   "^i(wifi.xbm) " + untrusted("^fg(red)net") + " 80%",
+			escape: dzenEscape,
+			want:   "^i(wifi.xbm) ^^fg(red)net 80%",
+		},
+		{
+			name:   "lemonbar",
+			text:   "%{T2}x%{T-} " + untrusted("%{F#f00}net") + " 80%",
+			escape: lemonbarEscape,
+			want:   "%{T2}x%{T-} %%{F#f00}net 80%",
+		},
+		{
+			name:   "several parts",
+			text:   untrusted("a^") + " " + untrusted("b^"),
+			escape: dzenEscape,
+			want:   "a^^ b^^",
+		},
+		{
+			name: "no escape",
+			text: "12" + untrusted("^C"),
+			want: "12^C",
+		},
+		{
+			name:   "nested markers",
+			text:   untrusted(untrustedEnd + "^" + untrustedStart),
+			escape: dzenEscape,
+			want:   "^^",
+		},
+	}
+
+	for _, test := range tests {
+		if got := renderText(test.text, test.escape); got != test.want {
+			t.Errorf("%s: renderText(%q) = %q; want %q", test.name, test.text, got, test.want)
+		}
+	}
+}
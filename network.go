@@ -0,0 +1,271 @@
+// Copyright 2017 Sergio Correia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type networkConfig struct {
+	// Interfaces lists the network interfaces to display. If empty,
+	// NetworkInterface is used, or, if that is empty or "auto", the
+	// interface that owns the default route.
+	Interfaces []string
+	// Aggregate shows the sum of the rates of all the interfaces,
+	// rather than each of them separately.
+	Aggregate bool
+	// Smoothing is the weight, from 0 up to (but excluding) 1, given
+	// to the previous rate when averaging it with a new sample. 0
+	// disables smoothing.
+	Smoothing float64
+}
+
+// netDevCounters holds the byte counters of an interface.
+type netDevCounters struct {
+	rx uint64
+	tx uint64
+}
+
+// netCollector reports either the rx or the tx rate of the configured
+// network interfaces.
+type netCollector struct {
+	name     string
+	counters map[string]*rateCounter
+}
+
+const (
+	// Flag of a route that is up, from linux/route.h.
+	rtfUp = 0x1
+)
+
+// defaultRouteInterface returns the interface that owns the default
+// route with the lowest metric, preferring IPv4 over IPv6 routes.
+func defaultRouteInterface() string {
+	if iface := defaultRouteInterface4(); len(iface) > 0 {
+		return iface
+	}
+	return defaultRouteInterface6()
+}
+
+func defaultRouteInterface4() string {
+	file, err := os.Open("/proc/net/route")
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	best, bestMetric := "", uint64(math.MaxUint64)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// Iface Destination Gateway Flags RefCnt Use Metric Mask MTU Window IRTT
+		// wlp2s0 00000000 0101A8C0 0003 0 0 600 00000000 0 0 0
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 8 || fields[1] != "00000000" || fields[7] != "00000000" {
+			continue
+		}
+
+		flags, err := strconv.ParseUint(fields[3], 16, 32)
+		if err != nil || flags&rtfUp == 0 {
+			continue
+		}
+		metric, err := strconv.ParseUint(fields[6], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		if metric < bestMetric {
+			best, bestMetric = fields[0], metric
+		}
+	}
+	return best
+}
+
+func defaultRouteInterface6() string {
+	file, err := os.Open("/proc/net/ipv6_route")
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	best, bestMetric := "", uint64(math.MaxUint64)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// dest prefix src prefix nexthop metric refcnt use flags iface
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 || strings.Trim(fields[0], "0") != "" || fields[1] != "00" || fields[9] == "lo" {
+			continue
+		}
+
+		flags, err := strconv.ParseUint(fields[8], 16, 32)
+		if err != nil || flags&rtfUp == 0 {
+			continue
+		}
+		metric, err := strconv.ParseUint(fields[5], 16, 64)
+		if err != nil {
+			continue
+		}
+
+		if metric < bestMetric {
+			best, bestMetric = fields[9], metric
+		}
+	}
+	return best
+}
+
+// activeInterfaces returns the network interfaces to display, as
+// configured, skipping the ones that do not exist at the moment.
+func activeInterfaces() []string {
+	candidates := config.Network.Interfaces
+	if len(candidates) == 0 {
+		if len(config.NetworkInterface) == 0 || config.NetworkInterface == "auto" {
+			candidates = []string{defaultRouteInterface()}
+		} else {
+			candidates = []string{config.NetworkInterface}
+		}
+	}
+
+	var ifaces []string
+	for _, iface := range candidates {
+		if len(iface) == 0 {
+			continue
+		}
+		if _, err := os.Stat("/sys/class/net/" + iface); err == nil {
+			ifaces = append(ifaces, iface)
+		}
+	}
+	return ifaces
+}
+
+// readNetDev returns the rx and tx byte counters of every network
+// interface, as reported by /proc/net/dev.
+func readNetDev() (map[string]netDevCounters, error) {
+	// Based in https://github.com/schachmat/gods/blob/master/gods.go
+	file, err := os.Open("/proc/net/dev")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	counters := make(map[string]netDevCounters)
+	var scanner = bufio.NewScanner(file)
+	for scanner.Scan() {
+		// wlp2s0: 1234 56 0 0 0 0 0 0 7890 12 0 0 0 0 0 0
+		line := strings.Replace(scanner.Text(), ":", " ", 1)
+		fields := strings.Fields(line)
+		if len(fields) < 10 {
+			continue
+		}
+
+		rx, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			// The header lines.
+			continue
+		}
+		tx, err := strconv.ParseUint(fields[9], 10, 64)
+		if err != nil {
+			continue
+		}
+		counters[fields[0]] = netDevCounters{rx: rx, tx: tx}
+	}
+	return counters, scanner.Err()
+}
+
+func (n *netCollector) Name() string {
+	return n.name
+}
+
+func (n *netCollector) Interval() time.Duration {
+	return time.Second
+}
+
+func (n *netCollector) Collect() {
+	key := n.Name()
+
+	ifaces := activeInterfaces()
+
+	// Forget the interfaces that are no longer active, e.g. when the
+	// default route moves to another one, so that once they are active
+	// again their rate is not averaged over the time they were not.
+	active := make(map[string]bool)
+	for _, iface := range ifaces {
+		active[iface] = true
+	}
+	for iface := range n.counters {
+		if !active[iface] {
+			delete(n.counters, iface)
+		}
+	}
+
+	if len(ifaces) == 0 {
+		removeKey(key)
+		return
+	}
+
+	counters, err := readNetDev()
+	if err != nil {
+		removeKey(key)
+		return
+	}
+
+	if n.counters == nil {
+		n.counters = make(map[string]*rateCounter)
+	}
+
+	t := time.Now()
+	value := ""
+	total := 0.0
+	for _, iface := range ifaces {
+		current, ok := counters[iface]
+		if !ok {
+			continue
+		}
+
+		now := current.rx
+		if key == "tx" {
+			now = current.tx
+		}
+
+		counter, ok := n.counters[iface]
+		if !ok {
+			// /proc/net/dev reports 64-bit counters, even on 32-bit
+			// kernels.
+			counter = &rateCounter{}
+			n.counters[iface] = counter
+		}
+		rate := counter.update(now, t, config.Network.Smoothing)
+		total += rate
+
+		if !config.Network.Aggregate && len(ifaces) > 1 {
+			value = fmt.Sprintf("%s %s %s", value, iface, strings.TrimSpace(formatBytes(int(rate))))
+		}
+	}
+
+	if config.Network.Aggregate || len(ifaces) == 1 {
+		value = formatBytes(int(total))
+	}
+
+	formatData(key, strings.TrimSpace(value), icons[key], formatDefault)
+}
+
+func init() {
+	registerCollector(&netCollector{name: "rx"})
+	registerCollector(&netCollector{name: "tx"})
+}
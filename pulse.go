@@ -0,0 +1,506 @@
+// Copyright 2017 Sergio Correia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pulseDevice holds the state of a PulseAudio/PipeWire sink or
+// source.
+type pulseDevice struct {
+	index       int
+	name        string
+	description string
+	volume      int
+	muted       bool
+	headphone   bool
+}
+
+// pulseEvent is a change notified by `pactl subscribe`, such as
+// "Event 'change' on sink #0".
+type pulseEvent struct {
+	kind     string
+	facility string
+	index    int
+}
+
+// pulseTracker follows the state of the sink or source selected in
+// the config file. Changes are notified by a subscription to the
+// sound server, so that they are displayed right away, no matter
+// where they come from.
+type pulseTracker struct {
+	sync.Mutex
+	// kind is either "sink" or "source".
+	kind   string
+	device pulseDevice
+	valid  bool
+	// changed is set when only the volume or mute state of the device
+	// changed, and reload when the whole list of devices needs to be
+	// read again.
+	changed bool
+	reload  bool
+	warned  bool
+}
+
+// volumeCollector reports the volume of the configured sink.
+type volumeCollector struct {
+	pulseTracker
+}
+
+// micCollector reports the volume and mute state of the configured
+// source, e.g. a microphone.
+type micCollector struct {
+	pulseTracker
+}
+
+const (
+	// defaultSinkSelector and defaultSourceSelector select whichever
+	// sink or source is the default one.
+	defaultSinkSelector   = "@DEFAULT_SINK@"
+	defaultSourceSelector = "@DEFAULT_SOURCE@"
+)
+
+var (
+	reInsideWhitespace = regexp.MustCompile(`[\s\p{Zs}]{2,}`)
+
+	// pulseHandlers are called for every event of the single
+	// `pactl subscribe` shared by all modules.
+	pulseHandlers []func(event pulseEvent)
+	pulseLock     sync.Mutex
+	pulseOnce     sync.Once
+)
+
+// pactl runs pactl with the given arguments, making sure its output is
+// not localized.
+func pactl(args ...string) ([]byte, error) {
+	cmd := exec.Command("pactl", args...)
+	cmd.Env = append(os.Environ(), "LC_ALL=C")
+	return cmd.Output()
+}
+
+// defaultDevice returns the name of the sink or source currently set
+// as default.
+func defaultDevice(kind string) (string, error) {
+	out, err := pactl("info")
+	if err != nil {
+		return "", err
+	}
+
+	// Default Sink: alsa_output.pci-0000_00_1f.3.analog-stereo
+	prefix := "Default Sink: "
+	if kind == "source" {
+		prefix = "Default Source: "
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(string(out)))
+	for scanner.Scan() {
+		if line := scanner.Text(); strings.HasPrefix(line, prefix) {
+			return strings.TrimSpace(strings.TrimPrefix(line, prefix)), nil
+		}
+	}
+	return "", fmt.Errorf("no default %s", kind)
+}
+
+// selectDevice picks the device described by selector, which may be
+// "@DEFAULT_SINK@"/"@DEFAULT_SOURCE@" (or empty), the device name, a
+// substring of its description or, as in older config files, its
+// index.
+func selectDevice(devices []pulseDevice, kind, selector string) (pulseDevice, error) {
+	if len(selector) == 0 || selector == defaultSinkSelector || selector == defaultSourceSelector {
+		name, err := defaultDevice(kind)
+		if err != nil {
+			return pulseDevice{}, err
+		}
+		selector = name
+	}
+
+	if index, err := strconv.Atoi(selector); err == nil {
+		for _, device := range devices {
+			if device.index == index {
+				return device, nil
+			}
+		}
+	}
+
+	for _, device := range devices {
+		if device.name == selector {
+			return device, nil
+		}
+	}
+
+	lower := strings.ToLower(selector)
+	for _, device := range devices {
+		// Monitors of sinks are sources as well, but not the ones
+		// people mean when describing their microphone.
+		if strings.HasSuffix(device.name, ".monitor") {
+			continue
+		}
+		if strings.Contains(strings.ToLower(device.description), lower) {
+			return device, nil
+		}
+	}
+	return pulseDevice{}, fmt.Errorf("no %s matches '%s'", kind, selector)
+}
+
+// parseVolume returns the volume percentage of the first channel in a
+// line such as "Volume: front-left: 43055 /  66% / -10.95 dB, ...".
+func parseVolume(line string) (int, error) {
+	for _, field := range strings.Fields(line) {
+		if strings.HasSuffix(field, "%") {
+			return strconv.Atoi(strings.TrimSuffix(field, "%"))
+		}
+	}
+	return 0, fmt.Errorf("no volume in '%s'", line)
+}
+
+// listDevices parses the output of `pactl list sinks` or
+// `pactl list sources`.
+func listDevices(kind string) ([]pulseDevice, error) {
+	out, err := pactl("list", kind+"s")
+	if err != nil {
+		return nil, err
+	}
+
+	// Sink #0
+	header := strings.ToUpper(kind[:1]) + kind[1:] + " #"
+
+	var devices []pulseDevice
+	var current *pulseDevice
+	var trimmed string
+
+	scanner := bufio.NewScanner(strings.NewReader(string(out)))
+	for scanner.Scan() {
+		trimmed = reInsideWhitespace.ReplaceAllString(strings.TrimLeft(scanner.Text(), " \t\r\n"), " ")
+		switch {
+		case strings.HasPrefix(trimmed, header):
+			index, err := strconv.Atoi(strings.TrimPrefix(trimmed, header))
+			if err != nil {
+				return nil, err
+			}
+			devices = append(devices, pulseDevice{index: index})
+			current = &devices[len(devices)-1]
+		case current == nil:
+			continue
+		case strings.HasPrefix(trimmed, "Name: "):
+			current.name = strings.TrimPrefix(trimmed, "Name: ")
+		case strings.HasPrefix(trimmed, "Description: "):
+			current.description = strings.TrimPrefix(trimmed, "Description: ")
+		case strings.HasPrefix(trimmed, "Mute: "):
+			// Mute: no
+			current.muted = strings.TrimPrefix(trimmed, "Mute: ") == "yes"
+		case strings.HasPrefix(trimmed, "Volume: "):
+			// Volume: front-left: 43055 /  66% / -10.95 dB,   front-right: 43055 /  66% / -10.95 dB
+			if current.volume, err = parseVolume(trimmed); err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(trimmed, "Active Port: "):
+			current.headphone = strings.Contains(strings.TrimPrefix(trimmed, "Active Port: "), "headphones")
+		}
+	}
+	return devices, scanner.Err()
+}
+
+// updateDevice refreshes only the volume and mute state of a device,
+// which is what changes most of the time.
+func updateDevice(kind string, device *pulseDevice) error {
+	out, err := pactl(fmt.Sprintf("get-%s-volume", kind), strconv.Itoa(device.index))
+	if err != nil {
+		return err
+	}
+	volume, err := parseVolume(string(out))
+	if err != nil {
+		return err
+	}
+
+	out, err = pactl(fmt.Sprintf("get-%s-mute", kind), strconv.Itoa(device.index))
+	if err != nil {
+		return err
+	}
+
+	device.volume = volume
+	device.muted = strings.TrimSpace(string(out)) == "Mute: yes"
+	return nil
+}
+
+// isRecording reports whether some application, other than a peak
+// meter such as the one in pavucontrol, is recording from a source.
+func isRecording(source int) bool {
+	out, err := pactl("list", "source-outputs")
+	if err != nil {
+		return false
+	}
+
+	var corked, peak bool
+	var from = -1
+	live := func() bool {
+		return from == source && !corked && !peak
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(string(out)))
+	for scanner.Scan() {
+		trimmed := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(trimmed, "Source Output #"):
+			if live() {
+				return true
+			}
+			from, corked, peak = -1, false, false
+		case strings.HasPrefix(trimmed, "Source: "):
+			from, _ = strconv.Atoi(strings.TrimPrefix(trimmed, "Source: "))
+		case strings.HasPrefix(trimmed, "Corked: "):
+			corked = strings.TrimPrefix(trimmed, "Corked: ") == "yes"
+		case trimmed == `media.name = "Peak detect"`:
+			peak = true
+		}
+	}
+	return live()
+}
+
+// parsePulseEvent parses a line of `pactl subscribe` output.
+func parsePulseEvent(line string) (pulseEvent, bool) {
+	// Event 'change' on sink #0
+	fields := strings.Fields(line)
+	if len(fields) < 4 || fields[0] != "Event" || fields[2] != "on" {
+		return pulseEvent{}, false
+	}
+
+	event := pulseEvent{kind: strings.Trim(fields[1], "'"), facility: fields[3], index: -1}
+	if len(fields) > 4 {
+		if index, err := strconv.Atoi(strings.TrimPrefix(fields[4], "#")); err == nil {
+			event.index = index
+		}
+	}
+	return event, true
+}
+
+// subscribePulse dispatches every PulseAudio event to pulseHandlers,
+// restarting `pactl subscribe` whenever it exits, e.g. if the sound
+// server is restarted.
+func subscribePulse() {
+	for {
+		cmd := exec.Command("pactl", "subscribe")
+		cmd.Env = append(os.Environ(), "LC_ALL=C")
+		stdout, err := cmd.StdoutPipe()
+		if err == nil {
+			err = cmd.Start()
+		}
+		if err != nil {
+			fmt.Printf("Unable to subscribe to sound events: %s\n", err)
+			return
+		}
+
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			event, ok := parsePulseEvent(scanner.Text())
+			if !ok {
+				continue
+			}
+
+			pulseLock.Lock()
+			for _, handle := range pulseHandlers {
+				handle(event)
+			}
+			pulseLock.Unlock()
+		}
+		cmd.Wait()
+
+		time.Sleep(5 * time.Second)
+	}
+}
+
+// watchPulse calls handle for every PulseAudio event. The subscription
+// is started along with the first handler.
+func watchPulse(handle func(event pulseEvent)) {
+	pulseLock.Lock()
+	pulseHandlers = append(pulseHandlers, handle)
+	pulseLock.Unlock()
+
+	pulseOnce.Do(func() { go subscribePulse() })
+}
+
+// refresh returns the current state of the device described by
+// selector, which is removed from the bar if not available.
+func (p *pulseTracker) refresh(key, selector string) (pulseDevice, bool) {
+	p.Lock()
+	changed := p.changed && !p.reload && p.valid
+	p.changed, p.reload = false, false
+	device := p.device
+	p.Unlock()
+
+	// Unless only the volume of the device changed, e.g. when
+	// collected on its interval, read every device again.
+	var err error
+	if !changed || updateDevice(p.kind, &device) != nil {
+		var devices []pulseDevice
+		if devices, err = listDevices(p.kind); err == nil {
+			device, err = selectDevice(devices, p.kind, selector)
+		}
+	}
+
+	p.Lock()
+	p.device = device
+	p.valid = err == nil
+	p.Unlock()
+
+	if err != nil {
+		// Only warn once, rather than on every interval, until the
+		// device shows up again.
+		if !p.warned {
+			if len(selector) == 0 {
+				selector = defaultSinkSelector
+				if p.kind == "source" {
+					selector = defaultSourceSelector
+				}
+			}
+			fmt.Printf("Sound %s '%s' is not valid: %s; please recheck the config file\n", p.kind, selector, err)
+			p.warned = true
+		}
+		removeKey(key)
+		return device, false
+	}
+	p.warned = false
+	return device, true
+}
+
+// handleEvent flags the device for a refresh, if the event concerns
+// it, and reports whether it does.
+func (p *pulseTracker) handleEvent(event pulseEvent) bool {
+	p.Lock()
+	defer p.Unlock()
+
+	switch {
+	case event.facility == p.kind && event.kind == "change":
+		if event.index != p.device.index {
+			return false
+		}
+		p.changed = true
+	case event.facility == p.kind || event.facility == "card" || event.facility == "server":
+		// Devices were added or removed, a port changed, e.g. when
+		// headphones were plugged in, or the default device changed.
+		p.reload = true
+	default:
+		return false
+	}
+	return true
+}
+
+func (v *volumeCollector) Name() string {
+	return "volume"
+}
+
+// Interval is long, as changes are notified by the sound server; it
+// only serves to resync the sink state.
+func (v *volumeCollector) Interval() time.Duration {
+	return 60 * time.Second
+}
+
+func (v *volumeCollector) Collect() {
+	key := v.Name()
+
+	sink, ok := v.refresh(key, config.SoundDevice)
+	if !ok {
+		return
+	}
+
+	var icon string
+
+	format := formatDefault
+	if sink.muted {
+		format = formatUrgent
+		if sink.headphone {
+			icon = icons["headphone_mute"]
+		} else {
+			if sink.volume > 40 {
+				icon = icons["volume_loud_mute"]
+			} else {
+				icon = icons["volume_low_mute"]
+			}
+		}
+	} else {
+		if sink.headphone {
+			icon = icons["headphone"]
+		} else {
+			if sink.volume > 40 {
+				icon = icons["volume_loud"]
+			} else {
+				icon = icons["volume_low"]
+			}
+		}
+	}
+
+	formatData(key, progressBar(sink.volume), icon, format)
+}
+
+func (v *volumeCollector) watch() {
+	watchPulse(func(event pulseEvent) {
+		if v.handleEvent(event) {
+			requestRefresh(v.Name())
+		}
+	})
+}
+
+func (m *micCollector) Name() string {
+	return "mic"
+}
+
+// Interval is long, as changes are notified by the sound server; it
+// only serves to resync the source state.
+func (m *micCollector) Interval() time.Duration {
+	return 60 * time.Second
+}
+
+func (m *micCollector) Collect() {
+	key := m.Name()
+
+	source, ok := m.refresh(key, config.Microphone)
+	if !ok {
+		return
+	}
+
+	icon := icons["mic"]
+	format := formatDefault
+	if source.muted {
+		icon = icons["mic_mute"]
+	} else if isRecording(source.index) {
+		// The microphone is live and someone may be listening.
+		format = formatUrgent
+	}
+
+	formatData(key, progressBar(source.volume), icon, format)
+}
+
+func (m *micCollector) watch() {
+	watchPulse(func(event pulseEvent) {
+		// Applications starting or stopping to record do not change
+		// the source itself.
+		if event.facility == "source-output" || m.handleEvent(event) {
+			requestRefresh(m.Name())
+		}
+	})
+}
+
+func init() {
+	registerCollector(&volumeCollector{pulseTracker{kind: "sink"}})
+	registerCollector(&micCollector{pulseTracker{kind: "source"}})
+}
@@ -0,0 +1,237 @@
+// Copyright 2017 Sergio Correia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// The default usage, in percentage of the quota, above which the
+	// quota module becomes urgent.
+	defaultQuotaThreshold = 90
+)
+
+type quotaConfig struct {
+	// QuotaMB is the allowance for each billing period, in megabytes.
+	// If 0, only the usage is shown, provided Interfaces is set; with
+	// neither, the module shows nothing.
+	QuotaMB int64
+	// BillingDay is the day of the month in which the usage is reset.
+	BillingDay int
+	// Threshold is the usage, in percentage of the quota, above which
+	// the module becomes urgent.
+	Threshold int
+	// Interfaces lists the metered interfaces. If empty, the ones
+	// displayed by the rx and tx modules are accounted.
+	Interfaces []string
+}
+
+// quotaState is persisted across restarts, in the state directory.
+type quotaState struct {
+	// BootID identifies the boot in which Counters were read; after
+	// a reboot, the interface counters start again from zero.
+	BootID      string
+	PeriodStart time.Time
+	// Totals holds the bytes transferred by each interface in the
+	// current billing period.
+	Totals map[string]uint64
+	// Counters holds the last rx + tx counters of each interface.
+	Counters map[string]uint64
+}
+
+// quotaCollector accumulates the traffic of the metered interfaces.
+type quotaCollector struct {
+	state  quotaState
+	loaded bool
+}
+
+func quotaStateFile() string {
+	return filepath.Join(stateDirectory(), "quota.json")
+}
+
+func bootID() string {
+	id, _ := readSysfsString("/proc/sys/kernel/random/boot_id")
+	return id
+}
+
+// billingPeriodStart returns the beginning of the billing period that
+// contains t.
+func billingPeriodStart(t time.Time, day int) time.Time {
+	if day < 1 {
+		day = 1
+	}
+
+	start := func(year int, month time.Month) time.Time {
+		// Months shorter than the billing day reset on their last
+		// day.
+		last := time.Date(year, month+1, 0, 0, 0, 0, 0, t.Location()).Day()
+		d := day
+		if d > last {
+			d = last
+		}
+		return time.Date(year, month, d, 0, 0, 0, 0, t.Location())
+	}
+
+	if s := start(t.Year(), t.Month()); !t.Before(s) {
+		return s
+	}
+	previous := time.Date(t.Year(), t.Month()-1, 1, 0, 0, 0, 0, t.Location())
+	return start(previous.Year(), previous.Month())
+}
+
+func (q *quotaCollector) load() {
+	q.state = quotaState{}
+
+	content, err := ioutil.ReadFile(quotaStateFile())
+	if err == nil {
+		if err = json.Unmarshal(content, &q.state); err != nil {
+			fmt.Printf("Unable to read quota state '%s': %s\n", quotaStateFile(), err)
+		}
+	}
+
+	if q.state.Totals == nil {
+		q.state.Totals = make(map[string]uint64)
+	}
+	if q.state.Counters == nil || q.state.BootID != bootID() {
+		// The counters restarted from zero since they were saved.
+		q.state.Counters = make(map[string]uint64)
+	}
+	q.loaded = true
+}
+
+func (q *quotaCollector) save() error {
+	if err := os.MkdirAll(stateDirectory(), 0700); err != nil {
+		return err
+	}
+
+	content, err := json.Marshal(q.state)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first, so that the state is never
+	// left truncated.
+	tmp := quotaStateFile() + ".tmp"
+	if err = ioutil.WriteFile(tmp, content, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, quotaStateFile())
+}
+
+func (q *quotaCollector) Name() string {
+	return "quota"
+}
+
+func (q *quotaCollector) Interval() time.Duration {
+	return 60 * time.Second
+}
+
+func (q *quotaCollector) Collect() {
+	key := q.Name()
+
+	// Without a quota, or metered interfaces, there is nothing to
+	// account, and no state should be written.
+	if config.Quota.QuotaMB <= 0 && len(config.Quota.Interfaces) == 0 {
+		removeKey(key)
+		return
+	}
+
+	if !q.loaded {
+		q.load()
+	}
+
+	counters, err := readNetDev()
+	if err != nil {
+		removeKey(key)
+		return
+	}
+
+	now := time.Now()
+	if start := billingPeriodStart(now, config.Quota.BillingDay); !start.Equal(q.state.PeriodStart) {
+		q.state.PeriodStart = start
+		q.state.Totals = make(map[string]uint64)
+	}
+
+	metered := config.Quota.Interfaces
+	if len(metered) == 0 {
+		metered = activeInterfaces()
+	}
+
+	for _, iface := range metered {
+		current, ok := counters[iface]
+		if !ok {
+			continue
+		}
+
+		// Interfaces seen for the first time in this boot are
+		// accounted from the moment they were created. Any decrease
+		// means the interface was recreated, e.g. a tethered phone
+		// plugged in again, and its counters started over from zero.
+		total := current.rx + current.tx
+		if old := q.state.Counters[iface]; total >= old {
+			q.state.Totals[iface] += total - old
+		} else {
+			q.state.Totals[iface] += total
+		}
+	}
+
+	// Keep the counters of every interface up to date, so that the
+	// traffic of an interface while it is not metered is not accounted
+	// once it is.
+	for iface, current := range counters {
+		q.state.Counters[iface] = current.rx + current.tx
+	}
+	q.state.BootID = bootID()
+
+	if err = q.save(); err != nil {
+		fmt.Printf("Unable to save quota state '%s': %s\n", quotaStateFile(), err)
+	}
+
+	var used uint64
+	for _, total := range q.state.Totals {
+		used += total
+	}
+
+	usage := strings.TrimSpace(formatBytes(int(used)))
+	if config.Quota.QuotaMB <= 0 {
+		formatData(key, usage, icons[key], formatDefault)
+		return
+	}
+
+	percent := int(used * 100 / uint64(config.Quota.QuotaMB*1000*1000))
+
+	threshold := config.Quota.Threshold
+	if threshold <= 0 {
+		threshold = defaultQuotaThreshold
+	}
+
+	format := formatDefault
+	if percent >= threshold {
+		format = formatUrgent
+	}
+
+	formatData(key, fmt.Sprintf("%s %s", progressBar(percent), usage), icons[key], format)
+}
+
+func init() {
+	registerCollector(&quotaCollector{})
+}
@@ -0,0 +1,188 @@
+// Copyright 2017 Sergio Correia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	hwmonPath   = "/sys/class/hwmon"
+	thermalPath = "/sys/class/thermal"
+
+	// The default temperatures, in °C, above which the temperature
+	// module becomes urgent, and shows its critical icon.
+	defaultTemperatureWarning  = 80
+	defaultTemperatureCritical = 95
+)
+
+type temperatureConfig struct {
+	// Sensors lists the temperature sensors to display, by label,
+	// such as "Package id 0", by chip and label, such as
+	// "amdgpu/edge", or by thermal zone type, such as "x86_pkg_temp".
+	// If empty, the CPU package temperature is shown.
+	Sensors []string
+	// Fans lists the fans to display, by label or by chip and label,
+	// such as "thinkpad/fan1".
+	Fans []string
+	// Warning and Critical are the temperatures, in °C, above which
+	// the module becomes urgent.
+	Warning  int
+	Critical int
+}
+
+// sensor is a temperature or fan reading, from hwmon or from a
+// thermal zone.
+type sensor struct {
+	chip  string
+	label string
+	value int
+}
+
+var (
+	// Labels of the CPU package temperature, from the coretemp and
+	// k10temp drivers, and the matching thermal zone type.
+	defaultTemperatureSensors = []string{"coretemp/Package id 0", "k10temp/Tctl", "k10temp/Tdie", "x86_pkg_temp"}
+)
+
+// readHwmon returns the readings of a kind of hwmon input, such as
+// "temp" or "fan". Unlabelled inputs are named after the input, e.g.
+// "temp1".
+func readHwmon(kind string) []sensor {
+	inputs, err := filepath.Glob(filepath.Join(hwmonPath, "hwmon*", kind+"*_input"))
+	if err != nil {
+		return nil
+	}
+
+	var sensors []sensor
+	for _, input := range inputs {
+		value, err := readSysfsInt(input)
+		if err != nil {
+			continue
+		}
+
+		dir := filepath.Dir(input)
+		chip, _ := readSysfsString(filepath.Join(dir, "name"))
+		name := strings.TrimSuffix(filepath.Base(input), "_input")
+		label, err := readSysfsString(filepath.Join(dir, name+"_label"))
+		if err != nil {
+			label = name
+		}
+
+		sensors = append(sensors, sensor{chip: chip, label: label, value: value})
+	}
+	return sensors
+}
+
+// readThermalZones returns the temperature of every thermal zone,
+// labelled by their type.
+func readThermalZones() []sensor {
+	zones, err := filepath.Glob(filepath.Join(thermalPath, "thermal_zone*"))
+	if err != nil {
+		return nil
+	}
+
+	var sensors []sensor
+	for _, zone := range zones {
+		value, err := readSysfsInt(filepath.Join(zone, "temp"))
+		if err != nil {
+			continue
+		}
+
+		kind, _ := readSysfsString(filepath.Join(zone, "type"))
+		sensors = append(sensors, sensor{chip: "thermal", label: kind, value: value})
+	}
+	return sensors
+}
+
+// findSensor returns the first sensor matching selector, either by
+// label or by chip and label.
+func findSensor(sensors []sensor, selector string) (sensor, bool) {
+	for _, s := range sensors {
+		if s.label == selector || fmt.Sprintf("%s/%s", s.chip, s.label) == selector {
+			return s, true
+		}
+	}
+	return sensor{}, false
+}
+
+func collectTemperature(key string) {
+	// Temperatures are exported in millidegrees Celsius.
+	temperatures := append(readHwmon("temp"), readThermalZones()...)
+
+	selected := config.Temperature.Sensors
+	if len(selected) == 0 {
+		for _, selector := range defaultTemperatureSensors {
+			if _, ok := findSensor(temperatures, selector); ok {
+				selected = []string{selector}
+				break
+			}
+		}
+	}
+
+	warning := config.Temperature.Warning
+	if warning <= 0 {
+		warning = defaultTemperatureWarning
+	}
+	critical := config.Temperature.Critical
+	if critical <= 0 {
+		critical = defaultTemperatureCritical
+	}
+
+	var values []string
+	highest := 0
+	for _, selector := range selected {
+		s, ok := findSensor(temperatures, selector)
+		if !ok {
+			continue
+		}
+
+		celsius := s.value / 1000
+		if celsius > highest {
+			highest = celsius
+		}
+		values = append(values, fmt.Sprintf("%d°C", celsius))
+	}
+
+	fans := readHwmon("fan")
+	for _, selector := range config.Temperature.Fans {
+		if s, ok := findSensor(fans, selector); ok {
+			values = append(values, fmt.Sprintf("%drpm", s.value))
+		}
+	}
+
+	if len(values) == 0 {
+		removeKey(key)
+		return
+	}
+
+	icon := icons[key]
+	format := formatDefault
+	if highest >= warning {
+		format = formatUrgent
+	}
+	if highest >= critical {
+		icon = icons["temperature_critical"]
+	}
+
+	formatData(key, strings.Join(values, " "), icon, format)
+}
+
+func init() {
+	registerFunc("temperature", 5*time.Second, collectTemperature)
+}
@@ -0,0 +1,171 @@
+// Copyright 2017 Sergio Correia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// terminalBackend prints the status to stdout, one line per update,
+// to be displayed in a terminal or in the tmux status line. Only the
+// modules are shown, and click actions are not available.
+type terminalBackend struct {
+	// markup is either "plain", "ansi" (truecolor escapes), "ansi256"
+	// or "tmux".
+	markup string
+}
+
+// parseHexColor returns the components of a "#rrggbb" color.
+func parseHexColor(color string) (r, g, b int, ok bool) {
+	if len(color) != 7 || color[0] != '#' {
+		return 0, 0, 0, false
+	}
+
+	rgb, err := strconv.ParseUint(color[1:], 16, 32)
+	if err != nil {
+		return 0, 0, 0, false
+	}
+	return int(rgb >> 16 & 0xff), int(rgb >> 8 & 0xff), int(rgb & 0xff), true
+}
+
+// xterm256Color returns the closest color of the xterm 256-color
+// palette, either from its 6x6x6 cube or from its grayscale ramp.
+func xterm256Color(r, g, b int) int {
+	levels := []int{0, 95, 135, 175, 215, 255}
+	cubeIndex := func(v int) int {
+		if v < 48 {
+			return 0
+		}
+		if v < 115 {
+			return 1
+		}
+		return (v - 35) / 40
+	}
+	distance := func(r2, g2, b2 int) int {
+		return (r-r2)*(r-r2) + (g-g2)*(g-g2) + (b-b2)*(b-b2)
+	}
+
+	ri, gi, bi := cubeIndex(r), cubeIndex(g), cubeIndex(b)
+	cube := 16 + 36*ri + 6*gi + bi
+
+	grayIndex := 23
+	if average := (r + g + b) / 3; average < 238 {
+		grayIndex = (average - 3) / 10
+		if grayIndex < 0 {
+			grayIndex = 0
+		}
+	}
+	grayLevel := 8 + 10*grayIndex
+
+	if distance(grayLevel, grayLevel, grayLevel) < distance(levels[ri], levels[gi], levels[bi]) {
+		return 232 + grayIndex
+	}
+	return cube
+}
+
+// ansiColor returns the escape sequence that sets the foreground, or
+// the background, to color.
+func (t *terminalBackend) ansiColor(color string, background bool) string {
+	r, g, b, ok := parseHexColor(color)
+	if !ok {
+		return ""
+	}
+
+	layer := 38
+	if background {
+		layer = 48
+	}
+
+	if t.markup == "ansi256" {
+		return fmt.Sprintf("\x1b[%d;5;%dm", layer, xterm256Color(r, g, b))
+	}
+	return fmt.Sprintf("\x1b[%d;2;%d;%d;%dm", layer, r, g, b)
+}
+
+// color returns the markup that sets the foreground, or the
+// background, to color.
+func (t *terminalBackend) color(color string, background bool) string {
+	if len(color) == 0 {
+		return ""
+	}
+
+	switch t.markup {
+	case "ansi", "ansi256":
+		return t.ansiColor(color, background)
+	case "tmux":
+		if background {
+			return fmt.Sprintf("#[bg=%s]", color)
+		}
+		return fmt.Sprintf("#[fg=%s]", color)
+	}
+	return ""
+}
+
+// reset returns the markup that restores the default colors.
+func (t *terminalBackend) reset() string {
+	switch t.markup {
+	case "ansi", "ansi256":
+		return "\x1b[0m"
+	case "tmux":
+		return "#[default]"
+	}
+	return ""
+}
+
+// escape escapes text so that it is not taken as markup.
+func (t *terminalBackend) escape(s string) string {
+	if t.markup == "tmux" {
+		return strings.Replace(s, "#", "##", -1)
+	}
+	return s
+}
+
+// render renders a segment with the terminal markup.
+func (t *terminalBackend) render(s segment) string {
+	content := t.color(s.bg, true)
+	if len(s.icon) > 0 {
+		content += fmt.Sprintf("%s%s ", t.color(s.iconFg, false), s.icon)
+	}
+	return content + fmt.Sprintf("%s%s%s", t.color(s.fg, false), renderText(s.text, t.escape), t.reset())
+}
+
+func (t *terminalBackend) start() {
+}
+
+func (t *terminalBackend) draw() {
+	t.update()
+}
+
+func (t *terminalBackend) status() string {
+	var status []string
+	for _, s := range append(moduleSegments("left", -1), moduleSegments("right", -1)...) {
+		status = append(status, t.render(s))
+	}
+	return strings.Join(status, " ")
+}
+
+func (t *terminalBackend) update() {
+	status := t.status()
+	if len(status) == 0 {
+		return
+	}
+
+	if _, err := fmt.Fprintln(stdout, status); err != nil {
+		log.Printf("terminalBackend.update: write failed: %v", err)
+	}
+}
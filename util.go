@@ -17,9 +17,14 @@ package main
 import (
 	"bufio"
 	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
 	"os/exec"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
 )
 
 const (
@@ -28,6 +33,18 @@ const (
 	adjustedWidthLen = 6
 )
 
+// rateCounter computes the rate of an ever increasing counter, such as
+// the bytes transferred by a network interface.
+type rateCounter struct {
+	old     uint64
+	oldTime time.Time
+	rate    float64
+	started bool
+	// bits is the width of the counter, if 32; otherwise, it is
+	// assumed to be 64-bit.
+	bits int
+}
+
 type screen struct {
 	width  int
 	height int
@@ -131,3 +148,141 @@ func getScreensInfo() {
 	}
 	fmt.Println("Detected screens: ", monitors)
 }
+
+// readSysfsString returns the trimmed contents of a sysfs attribute.
+func readSysfsString(path string) (string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(content)), nil
+}
+
+// readSysfsInt returns the value of a numeric sysfs attribute.
+func readSysfsInt(path string) (int, error) {
+	content, err := readSysfsString(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(content)
+}
+
+// watchFiles calls changed whenever one of the given files is
+// modified, as reported by inotify. It blocks until timeout elapses,
+// in which case it returns nil, or until reading the events fails.
+func watchFiles(paths []string, timeout time.Duration, changed func()) error {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return err
+	}
+
+	// Being non-blocking, the descriptor is handled by the runtime
+	// poller, which supports read deadlines.
+	events := os.NewFile(uintptr(fd), "inotify")
+	defer events.Close()
+
+	watched := 0
+	for _, path := range paths {
+		if _, err = syscall.InotifyAddWatch(fd, path, syscall.IN_MODIFY); err == nil {
+			watched++
+		}
+	}
+	if watched == 0 {
+		return fmt.Errorf("unable to watch any of %v", paths)
+	}
+
+	if err = events.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+
+	// Room for a batch of events; their contents do not matter, as
+	// any of them means a change.
+	buffer := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := events.Read(buffer)
+		if os.IsTimeout(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			changed()
+		}
+	}
+}
+
+// counterDelta returns how much a counter, bits wide, increased
+// between two samples. Only 32-bit counters are assumed to wrap
+// around; any other decrease means the counter was reset, e.g. when a
+// network interface was recreated, and the sample is unusable.
+func counterDelta(old, now uint64, bits int) (uint64, bool) {
+	if now >= old {
+		return now - old, true
+	}
+
+	if bits == 32 && old <= math.MaxUint32 {
+		delta := now + (math.MaxUint32 - old) + 1
+		// A genuine wrap around cannot account for more than half of
+		// the counter range in a single sample.
+		if delta < math.MaxUint32/2 {
+			return delta, true
+		}
+	}
+	return 0, false
+}
+
+// kernelLongBits returns the width of the kernel unsigned long, in
+// which some /proc counters, like the sectors in /proc/diskstats, are
+// kept.
+func kernelLongBits() int {
+	var uts syscall.Utsname
+	if err := syscall.Uname(&uts); err != nil {
+		return 64
+	}
+
+	var machine []byte
+	for _, c := range uts.Machine {
+		if c == 0 {
+			break
+		}
+		machine = append(machine, byte(c))
+	}
+
+	if strings.Contains(string(machine), "64") || string(machine) == "s390x" {
+		return 64
+	}
+	return 32
+}
+
+// update feeds a new sample to the counter, and returns the current
+// rate, per second. The rate is smoothed by averaging it with the
+// previous one, given the weight smoothing, from 0 (no smoothing) up
+// to (but excluding) 1.
+func (c *rateCounter) update(now uint64, t time.Time, smoothing float64) float64 {
+	defer func() { c.old, c.oldTime, c.started = now, t, true }()
+
+	if !c.started {
+		return c.rate
+	}
+
+	elapsed := t.Sub(c.oldTime).Seconds()
+	if elapsed <= 0 {
+		return c.rate
+	}
+
+	delta, ok := counterDelta(c.old, now, c.bits)
+	if !ok {
+		// The counter was reset; start over from this sample.
+		c.rate = 0
+		return c.rate
+	}
+
+	sample := float64(delta) / elapsed
+
+	if smoothing < 0 || smoothing >= 1 {
+		smoothing = 0
+	}
+	c.rate = smoothing*c.rate + (1-smoothing)*sample
+	return c.rate
+}
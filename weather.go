@@ -0,0 +1,343 @@
+// Copyright 2017 Sergio Correia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultWeatherURL = "https://api.open-meteo.com/v1/forecast"
+
+	// The cached conditions are still shown while offline, until they
+	// are older than this.
+	weatherMaxAge = 3 * time.Hour
+
+	weatherTimeout = 10 * time.Second
+
+	// Collect also runs when the bar is refreshed, e.g. once a fetch
+	// completes, so fetches are never closer than this.
+	weatherMinInterval = time.Minute
+)
+
+type weatherConfig struct {
+	// URL is the forecast endpoint of an Open-Meteo compatible API.
+	// Query parameters already present in it are kept as they are.
+	URL string
+	// Latitude and Longitude are the location of the forecast. Unless
+	// they, or URL, set a location, the module shows nothing.
+	Latitude  float64
+	Longitude float64
+	// Unit is either "C" (the default) or "F".
+	Unit string
+}
+
+// weatherReport holds the current conditions, as cached on disk.
+type weatherReport struct {
+	Temperature float64
+	Unit        string
+	// Code is the WMO weather interpretation code.
+	Code    int
+	IsDay   bool
+	Fetched time.Time
+}
+
+// openMeteoResponse is the subset of the forecast API response that
+// is used. Older versions of the API only provide current_weather.
+type openMeteoResponse struct {
+	Current *struct {
+		Temperature float64 `json:"temperature_2m"`
+		WeatherCode int     `json:"weather_code"`
+		IsDay       int     `json:"is_day"`
+	} `json:"current"`
+	CurrentUnits struct {
+		Temperature string `json:"temperature_2m"`
+	} `json:"current_units"`
+	CurrentWeather *struct {
+		Temperature float64 `json:"temperature"`
+		WeatherCode int     `json:"weathercode"`
+		IsDay       int     `json:"is_day"`
+	} `json:"current_weather"`
+}
+
+// weatherCollector fetches the current conditions in the background,
+// so that a slow or unreachable endpoint never stalls the bar.
+type weatherCollector struct {
+	mu       sync.Mutex
+	report   *weatherReport
+	loaded   bool
+	fetching bool
+	// attempted is when the last fetch completed, whether or not it
+	// succeeded.
+	attempted time.Time
+	warned    bool
+}
+
+func weatherCacheFile() string {
+	return filepath.Join(cacheDirectory(), "weather.json")
+}
+
+// weatherConfigured reports whether a location is configured, either
+// with Latitude and Longitude, or in the query of URL. The endpoint is
+// never contacted otherwise.
+func weatherConfigured() bool {
+	if config.Weather.Latitude != 0 || config.Weather.Longitude != 0 {
+		return true
+	}
+
+	u, err := url.Parse(config.Weather.URL)
+	if err != nil {
+		return false
+	}
+	query := u.Query()
+	return len(query.Get("latitude")) > 0 && len(query.Get("longitude")) > 0
+}
+
+// weatherURL returns the endpoint to fetch, with the location, unit
+// and requested variables added to its query.
+func weatherURL() (string, error) {
+	endpoint := config.Weather.URL
+	if len(endpoint) == 0 {
+		endpoint = defaultWeatherURL
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+
+	query := u.Query()
+	setDefault := func(key, value string) {
+		if len(query.Get(key)) == 0 {
+			query.Set(key, value)
+		}
+	}
+	setDefault("latitude", strconv.FormatFloat(config.Weather.Latitude, 'f', -1, 64))
+	setDefault("longitude", strconv.FormatFloat(config.Weather.Longitude, 'f', -1, 64))
+	setDefault("current", "temperature_2m,weather_code,is_day")
+	if config.Weather.Unit == "F" {
+		setDefault("temperature_unit", "fahrenheit")
+	}
+
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// fetchWeather retrieves the current conditions from the configured
+// endpoint.
+func fetchWeather() (*weatherReport, error) {
+	endpoint, err := weatherURL()
+	if err != nil {
+		return nil, err
+	}
+
+	client := http.Client{Timeout: weatherTimeout}
+	resp, err := client.Get(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", withoutQuery(endpoint), resp.Status)
+	}
+
+	var response openMeteoResponse
+	if err = json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, err
+	}
+
+	report := &weatherReport{Unit: "°C", Fetched: time.Now()}
+	if config.Weather.Unit == "F" {
+		report.Unit = "°F"
+	}
+
+	switch {
+	case response.Current != nil:
+		report.Temperature = response.Current.Temperature
+		report.Code = response.Current.WeatherCode
+		report.IsDay = response.Current.IsDay != 0
+		if len(response.CurrentUnits.Temperature) > 0 {
+			report.Unit = response.CurrentUnits.Temperature
+		}
+	case response.CurrentWeather != nil:
+		report.Temperature = response.CurrentWeather.Temperature
+		report.Code = response.CurrentWeather.WeatherCode
+		report.IsDay = response.CurrentWeather.IsDay != 0
+	default:
+		return nil, fmt.Errorf("%s returned no current conditions", withoutQuery(endpoint))
+	}
+	return report, nil
+}
+
+// withoutQuery strips the query from an endpoint, for error messages.
+func withoutQuery(endpoint string) string {
+	parsed, err := url.Parse(endpoint)
+	if err != nil {
+		return endpoint
+	}
+	parsed.RawQuery = ""
+	return parsed.String()
+}
+
+// weatherIcon maps a WMO weather interpretation code to the name of
+// its icon.
+func weatherIcon(code int, isDay bool) string {
+	switch {
+	case code == 0 || code == 1:
+		if !isDay {
+			return "weather_clear_night"
+		}
+		return "weather_clear"
+	case code == 2:
+		return "weather_partly_cloudy"
+	case code == 3:
+		return "weather_cloudy"
+	case code == 45 || code == 48:
+		return "weather_fog"
+	case code >= 51 && code <= 67, code >= 80 && code <= 82:
+		return "weather_rain"
+	case code >= 71 && code <= 77, code == 85 || code == 86:
+		return "weather_snow"
+	case code >= 95 && code <= 99:
+		return "weather_storm"
+	}
+	return "weather"
+}
+
+func (w *weatherCollector) load() {
+	w.loaded = true
+
+	content, err := ioutil.ReadFile(weatherCacheFile())
+	if err != nil {
+		return
+	}
+
+	var report weatherReport
+	if err = json.Unmarshal(content, &report); err != nil {
+		fmt.Printf("Unable to read weather cache '%s': %s\n", weatherCacheFile(), err)
+		return
+	}
+	w.report = &report
+}
+
+func (w *weatherCollector) save(report *weatherReport) error {
+	if err := os.MkdirAll(cacheDirectory(), 0700); err != nil {
+		return err
+	}
+
+	content, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(weatherCacheFile(), content, 0600)
+}
+
+// update fetches the current conditions and asks for the bar to be
+// redrawn with them.
+func (w *weatherCollector) update() {
+	report, err := fetchWeather()
+
+	w.mu.Lock()
+	w.fetching = false
+	if err != nil {
+		// Keep showing the cached conditions while offline, and only
+		// complain once until the endpoint is reachable again.
+		if !w.warned {
+			fmt.Printf("Unable to fetch weather: %s\n", err)
+			w.warned = true
+		}
+	} else {
+		w.report = report
+		w.warned = false
+	}
+	w.attempted = time.Now()
+	w.mu.Unlock()
+
+	if err == nil {
+		if err = w.save(report); err != nil {
+			fmt.Printf("Unable to save weather cache '%s': %s\n", weatherCacheFile(), err)
+		}
+	}
+	requestRefresh(w.Name())
+}
+
+func (w *weatherCollector) Name() string {
+	return "weather"
+}
+
+func (w *weatherCollector) Interval() time.Duration {
+	return 15 * time.Minute
+}
+
+func (w *weatherCollector) Collect() {
+	key := w.Name()
+
+	if !weatherConfigured() {
+		removeKey(key)
+		return
+	}
+
+	w.mu.Lock()
+	if !w.loaded {
+		w.load()
+	}
+	fetch := !w.fetching && time.Since(w.attempted) >= weatherMinInterval
+	if fetch {
+		w.fetching = true
+	}
+	w.mu.Unlock()
+
+	if fetch {
+		if onceMode {
+			// The process exits right after printing, so a background
+			// fetch would be lost.
+			w.update()
+		} else {
+			go w.update()
+		}
+	}
+
+	w.mu.Lock()
+	report := w.report
+	w.mu.Unlock()
+
+	if report == nil || time.Since(report.Fetched) > weatherMaxAge {
+		removeKey(key)
+		return
+	}
+
+	icon, ok := icons[weatherIcon(report.Code, report.IsDay)]
+	if !ok {
+		icon = icons[key]
+	}
+
+	temperature := int(math.Round(report.Temperature))
+	formatData(key, fmt.Sprintf("%d%s", temperature, untrusted(report.Unit)), icon, formatDefault)
+}
+
+func init() {
+	registerCollector(&weatherCollector{})
+}
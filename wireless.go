@@ -0,0 +1,186 @@
+// Copyright 2017 Sergio Correia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// The maximum link quality reported by most drivers in
+	// /proc/net/wireless.
+	maxLinkQuality = 70
+)
+
+type wirelessConfig struct {
+	// Bitrate also shows the transmit bitrate.
+	Bitrate bool
+}
+
+// wirelessLink holds the state of a wireless connection.
+type wirelessLink struct {
+	connected bool
+	ssid      string
+	quality   int
+	bitrate   string
+}
+
+// readWirelessQuality returns the link quality of every wireless
+// interface listed in /proc/net/wireless, in percentage.
+func readWirelessQuality() (map[string]int, error) {
+	file, err := os.Open("/proc/net/wireless")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	qualities := make(map[string]int)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// wlp2s0: 0000   54.  -56.  -256        0      0      0      0     10        0
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || !strings.HasSuffix(fields[0], ":") {
+			continue
+		}
+
+		link, err := strconv.ParseFloat(strings.TrimSuffix(fields[2], "."), 64)
+		if err != nil {
+			continue
+		}
+
+		quality := int(link * 100 / maxLinkQuality)
+		if quality > 100 {
+			quality = 100
+		}
+		qualities[strings.TrimSuffix(fields[0], ":")] = quality
+	}
+	return qualities, scanner.Err()
+}
+
+// readWirelessLink asks iw for the SSID and bitrate of the connection
+// of an interface.
+func readWirelessLink(iface string, link *wirelessLink) error {
+	out, err := exec.Command("iw", "dev", iface, "link").Output()
+	if err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(string(out)))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, "Connected to"):
+			link.connected = true
+		case strings.HasPrefix(line, "Not connected"):
+			link.connected = false
+		case strings.HasPrefix(line, "SSID: "):
+			// SSID: MyNetwork
+			link.ssid = strings.TrimPrefix(line, "SSID: ")
+		case strings.HasPrefix(line, "tx bitrate: "):
+			// tx bitrate: 866.7 MBit/s VHT-MCS 9 80MHz short GI VHT-NSS 2
+			if fields := strings.Fields(strings.TrimPrefix(line, "tx bitrate: ")); len(fields) >= 2 {
+				link.bitrate = fmt.Sprintf("%s%s", fields[0], strings.Replace(fields[1], "Bit/s", "b/s", 1))
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// isWireless reports whether a network interface is a wireless one.
+func isWireless(iface string) bool {
+	_, err := os.Stat(fmt.Sprintf("/sys/class/net/%s/wireless", iface))
+	return err == nil
+}
+
+// wirelessInterface returns the wireless interface to display: the
+// first of the active network interfaces that is wireless or, if none
+// is, any wireless interface in the system.
+func wirelessInterface(qualities map[string]int) string {
+	for _, iface := range activeInterfaces() {
+		if isWireless(iface) {
+			return iface
+		}
+	}
+
+	var ifaces []string
+	for iface := range qualities {
+		ifaces = append(ifaces, iface)
+	}
+	if len(ifaces) == 0 {
+		return ""
+	}
+	sort.Strings(ifaces)
+	return ifaces[0]
+}
+
+func collectWireless(key string) {
+	qualities, err := readWirelessQuality()
+	if err != nil {
+		removeKey(key)
+		return
+	}
+
+	iface := wirelessInterface(qualities)
+	if len(iface) == 0 {
+		removeKey(key)
+		return
+	}
+
+	// Interfaces are listed in /proc/net/wireless even when not
+	// associated, with no link quality.
+	quality := qualities[iface]
+	link := wirelessLink{connected: quality > 0, quality: quality}
+	if err = readWirelessLink(iface, &link); err != nil {
+		// Without iw, only the quality is known.
+		link.ssid = iface
+	}
+
+	if !link.connected {
+		formatData(key, "down", icons["wifi_off"], formatUrgent)
+		return
+	}
+
+	var iconName string
+	switch {
+	case link.quality > 75:
+		iconName = "wifi_full"
+	case link.quality > 50:
+		iconName = "wifi_three_quarters"
+	case link.quality > 25:
+		iconName = "wifi_half"
+	case link.quality > 10:
+		iconName = "wifi_quarter"
+	default:
+		iconName = "wifi_empty"
+	}
+
+	value := fmt.Sprintf("%s %d%%", untrusted(link.ssid), link.quality)
+	if config.Wireless.Bitrate && len(link.bitrate) > 0 {
+		value = fmt.Sprintf("%s %s", value, link.bitrate)
+	}
+
+	formatData(key, value, icons[iconName], formatDefault)
+}
+
+func init() {
+	registerFunc("wifi", 10*time.Second, collectWireless)
+}